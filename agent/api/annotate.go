@@ -0,0 +1,143 @@
+package api
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rafikurnia/measurement-measurer/tasks"
+	"github.com/rafikurnia/measurement-measurer/utils/geoip"
+)
+
+const (
+	// maxConcurrentLookups bounds the reverse DNS lookups of a result.
+	maxConcurrentLookups = 8
+	// reverseLookupTimeout bounds each reverse DNS lookup.
+	reverseLookupTimeout = time.Second
+)
+
+// annotateMeasurements adds an "annotations" object to every encoded result
+// of the measurements, keyed by every public IP address found in it, with
+// the AS, the country and the reverse DNS names of the address. Each
+// address is looked up once per request, however many samples and targets
+// it appears in.
+func annotateMeasurements(ctx context.Context, db *geoip.DB, measurements map[string]*tasks.Measurement) {
+	results := make([]map[string]interface{}, 0, len(measurements))
+	for _, m := range measurements {
+		if m.Result != nil {
+			results = append(results, m.Result)
+		}
+		for _, sample := range m.Samples {
+			if sample.Result != nil {
+				results = append(results, sample.Result)
+			}
+		}
+	}
+
+	ips := make(map[string]net.IP)
+	for _, result := range results {
+		collectIPs(result, ips)
+	}
+	if len(ips) == 0 {
+		return
+	}
+	cache := lookupIPs(ctx, db, ips)
+
+	for _, result := range results {
+		found := make(map[string]net.IP)
+		collectIPs(result, found)
+		if len(found) == 0 {
+			continue
+		}
+
+		annotations := make(map[string]interface{}, len(found))
+		for address := range found {
+			annotations[address] = cache[address]
+		}
+		result["annotations"] = annotations
+	}
+}
+
+// lookupIPs annotates the addresses concurrently, at most
+// maxConcurrentLookups at a time.
+func lookupIPs(ctx context.Context, db *geoip.DB, ips map[string]net.IP) map[string]map[string]interface{} {
+	annotations := make(map[string]map[string]interface{}, len(ips))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, maxConcurrentLookups)
+	for address, ip := range ips {
+		wg.Add(1)
+		go func(address string, ip net.IP) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			annotation := annotateIP(ctx, db, ip)
+
+			mu.Lock()
+			annotations[address] = annotation
+			mu.Unlock()
+		}(address, ip)
+	}
+	wg.Wait()
+	return annotations
+}
+
+// annotateIP looks an address up in the databases and in the DNS.
+func annotateIP(ctx context.Context, db *geoip.DB, ip net.IP) map[string]interface{} {
+	annotation := make(map[string]interface{})
+
+	if db != nil {
+		if info, err := db.Lookup(ip); err == nil {
+			if info.ASN != 0 {
+				annotation["asn"] = info.ASN
+				annotation["as_organization"] = info.ASOrganization
+			}
+			if info.CountryCode != "" {
+				annotation["country"] = info.Country
+				annotation["country_code"] = info.CountryCode
+			}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, reverseLookupTimeout)
+	defer cancel()
+	if names, err := net.DefaultResolver.LookupAddr(ctx, ip.String()); err == nil && len(names) > 0 {
+		for i, name := range names {
+			names[i] = strings.TrimSuffix(name, ".")
+		}
+		sort.Strings(names)
+		annotation["rdns"] = names
+	}
+
+	return annotation
+}
+
+// collectIPs walks an encoded result and gathers the public IP addresses
+// it contains, either alone or followed by a port.
+func collectIPs(v interface{}, ips map[string]net.IP) {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		for _, value := range x {
+			collectIPs(value, ips)
+		}
+	case []interface{}:
+		for _, value := range x {
+			collectIPs(value, ips)
+		}
+	case string:
+		host := x
+		if h, _, err := net.SplitHostPort(x); err == nil {
+			host = h
+		}
+		ip := net.ParseIP(host)
+		if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+			ip.IsLinkLocalUnicast() || ip.IsMulticast() {
+			return
+		}
+		ips[ip.String()] = ip
+	}
+}
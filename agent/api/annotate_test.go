@@ -0,0 +1,71 @@
+package api
+
+import (
+	"net"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollectIPs(t *testing.T) {
+	tests := []struct {
+		name   string
+		result interface{}
+		want   []string
+	}{
+		{
+			name:   "address",
+			result: map[string]interface{}{"address": "8.8.8.8"},
+			want:   []string{"8.8.8.8"},
+		},
+		{
+			name:   "address and port",
+			result: map[string]interface{}{"remote_address": "[2001:4860:4860::8888]:443", "local": "8.8.4.4:53"},
+			want:   []string{"2001:4860:4860::8888", "8.8.4.4"},
+		},
+		{
+			name: "nested",
+			result: map[string]interface{}{
+				"hops": []interface{}{
+					map[string]interface{}{"address": "1.1.1.1", "probes": []interface{}{map[string]interface{}{"address": "1.0.0.1"}}},
+					map[string]interface{}{"address": "1.1.1.1"},
+				},
+			},
+			want: []string{"1.0.0.1", "1.1.1.1"},
+		},
+		{
+			name: "not public",
+			result: map[string]interface{}{
+				"loopback":    "127.0.0.1",
+				"private":     "10.0.0.1",
+				"unspecified": "::",
+				"link-local":  "fe80::1",
+				"multicast":   "224.0.0.1",
+			},
+			want: []string{},
+		},
+		{
+			name:   "not an address",
+			result: map[string]interface{}{"target": "google.com", "rtt_ms": 1.5, "ttl": 64, "received": true, "port": "example.com:443"},
+			want:   []string{},
+		},
+		{
+			name:   "canonical form",
+			result: []interface{}{"2001:4860:4860:0:0:0:0:8888", "::ffff:8.8.8.8"},
+			want:   []string{"2001:4860:4860::8888", "8.8.8.8"},
+		},
+	}
+
+	for _, tt := range tests {
+		ips := make(map[string]net.IP)
+		collectIPs(tt.result, ips)
+
+		addresses := make([]string, 0, len(ips))
+		for address := range ips {
+			addresses = append(addresses, address)
+		}
+		sort.Strings(addresses)
+		assert.Equal(t, tt.want, addresses, "The public addresses must be collected: %s", tt.name)
+	}
+}
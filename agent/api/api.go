@@ -5,24 +5,59 @@ import (
 	"net/http"
 	"os"
 
+	"cloud.google.com/go/firestore"
+
 	"github.com/gin-gonic/gin"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
 	"github.com/rafikurnia/measurement-measurer/utils"
+	"github.com/rafikurnia/measurement-measurer/utils/geoip"
 )
 
-func SetupRouter() (*gin.Engine, error) {
+// Server holds the long-lived clients shared by every request, so that
+// they are not set up again for each measurement.
+type Server struct {
+	Firestore *firestore.Client
+	// GeoIP is used to annotate the addresses found in results. It may be
+	// nil.
+	GeoIP *geoip.DB
+	// Modules are the presets of the /probe endpoint. The default modules
+	// are used when it is nil.
+	Modules map[string]ProbeModule
+
+	// firestoreCheck caches the outcome of the readiness check of Firestore.
+	firestoreCheck cachedCheck
+}
+
+func SetupRouter(s *Server) (*gin.Engine, error) {
 	firestoreCollectionName = os.Getenv("FIRESTORE_COLLECTION_NAME")
+	if s.Modules == nil {
+		s.Modules = DefaultProbeModules()
+	}
 
 	router := gin.Default()
 	router.HandleMethodNotAllowed = true
+	router.ContextWithFallback = true
 
 	if err := router.SetTrustedProxies(nil); err != nil {
 		return nil, fmt.Errorf("router.SetTrustedProxies -> %w", err)
 	}
 
+	// The health and metrics endpoints are polled often, so they are
+	// registered before the middleware that traces requests.
+	router.GET("/healthz", s.healthz)
+	router.GET("/readyz", s.readyz)
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+
+	router.Use(requestScope)
+
+	// Measures a target on demand for Prometheus, like blackbox_exporter.
+	router.GET("/probe", s.probe)
+
 	v1 := router.Group("/api/v1")
 	{
-		v1.POST("/measurements", runMeasurement)
+		v1.POST("/measurements", s.runMeasurement)
 	}
 
 	router.NoRoute(func(ctx *gin.Context) {
@@ -2,7 +2,14 @@ package api
 
 type benchmark struct {
 	BeginningTime            int64 `json:"beginning_time,omitempty"`
+	ScheduleTime             int64 `json:"schedule_time,omitempty"`
 	MeasurementBeginningTime int64 `json:"measurement_beginning_time,omitempty"`
 	MeasurementEndingTime    int64 `json:"measurement_ending_time,omitempty"`
 	EndingTime               int64 `json:"ending_time,omitempty"`
+	FirestoreReadTime        int64 `json:"firestore_read_time,omitempty"`
+	FirestoreWriteTime       int64 `json:"firestore_write_time,omitempty"`
+	ColdStart                bool  `json:"cold_start,omitempty"`
+	// The time spent in the handler before and after the measurement.
+	OverheadBefore int64 `json:"overhead_before,omitempty"`
+	OverheadAfter  int64 `json:"overhead_after,omitempty"`
 }
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/rafikurnia/measurement-measurer/probes"
+	"github.com/rafikurnia/measurement-measurer/utils"
+)
+
+const (
+	// readinessTimeout bounds the check of the Firestore connection.
+	readinessTimeout = 2 * time.Second
+	// readinessCacheTTL is how long the outcome of the Firestore check is
+	// reused, since every check is a billed document read.
+	readinessCacheTTL = 30 * time.Second
+)
+
+// readinessResponse is the body of /readyz, with the status of Firestore
+// and of every probe.
+type readinessResponse struct {
+	utils.HTTPResponse
+	Firestore string            `json:"firestore"`
+	Probes    map[string]string `json:"probes"`
+}
+
+// cachedCheck remembers the outcome of a check for readinessCacheTTL.
+type cachedCheck struct {
+	mu      sync.Mutex
+	checked time.Time
+	err     error
+}
+
+// run returns the outcome of the last check if it is recent enough, and
+// runs check again otherwise.
+func (c *cachedCheck) run(ctx context.Context, check func(context.Context) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.checked.IsZero() && time.Since(c.checked) < readinessCacheTTL {
+		return c.err
+	}
+	c.err = check(ctx)
+	c.checked = time.Now()
+	return c.err
+}
+
+// healthz reports that the server is up.
+func (s *Server) healthz(ctx *gin.Context) {
+	utils.Throws(ctx, http.StatusOK, http.StatusText(http.StatusOK))
+}
+
+// readyz reports whether the instance can measure, with the status of
+// Firestore and of every probe in the body. The instance is only unready
+// when Firestore is unreachable or a probe misses its command; a probe that
+// cannot open its socket is reported but does not stop the others.
+func (s *Server) readyz(ctx *gin.Context) {
+	var firestoreErr error
+	if s.Firestore == nil {
+		firestoreErr = errors.New("The Firestore client is not available")
+	} else {
+		firestoreErr = s.firestoreCheck.run(ctx.Request.Context(), func(ctx context.Context) error {
+			ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+			defer cancel()
+			return pingFirestore(ctx, s.Firestore)
+		})
+	}
+
+	response := newReadinessResponse(firestoreErr, probes.Names(), probes.Check())
+	ctx.JSON(response.Code, response)
+}
+
+// newReadinessResponse reports the status of Firestore and of the probes,
+// given the errors of their checks.
+func newReadinessResponse(firestoreErr error, names []string, failed map[string]error) *readinessResponse {
+	response := &readinessResponse{
+		HTTPResponse: utils.HTTPResponse{Code: http.StatusOK, Message: http.StatusText(http.StatusOK)},
+		Firestore:    "ok",
+		Probes:       make(map[string]string, len(names)),
+	}
+	ready := true
+
+	if firestoreErr != nil {
+		response.Firestore = firestoreErr.Error()
+		ready = false
+	}
+	for _, name := range names {
+		err, ok := failed[name]
+		if !ok {
+			response.Probes[name] = "ok"
+			continue
+		}
+		response.Probes[name] = err.Error()
+		if errors.Is(err, probes.ErrMissingBinary) {
+			ready = false
+		}
+	}
+
+	if !ready {
+		response.Code = http.StatusServiceUnavailable
+		response.Message = http.StatusText(http.StatusServiceUnavailable)
+	}
+	return response
+}
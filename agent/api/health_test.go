@@ -0,0 +1,124 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/rafikurnia/measurement-measurer/probes"
+	"github.com/rafikurnia/measurement-measurer/tasks"
+)
+
+// serve sends a GET request to a new router and returns the response.
+func serve(t *testing.T, s *Server, path string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	router, err := SetupRouter(s)
+	if !assert.Nil(t, err, "The router must be set up.") {
+		t.FailNow()
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w
+}
+
+func TestHealthz(t *testing.T) {
+	w := serve(t, &Server{}, "/healthz")
+
+	assert.Equal(t, http.StatusOK, w.Code, "The server must be healthy without Firestore.")
+}
+
+func TestReadyzWithoutFirestore(t *testing.T) {
+	w := serve(t, &Server{}, "/readyz")
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code, "The instance must not be ready without Firestore.")
+
+	var response readinessResponse
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &response), "The body must be JSON.")
+	assert.Equal(t, http.StatusServiceUnavailable, response.Code, "The body must hold the status code.")
+	assert.Equal(t, "The Firestore client is not available", response.Firestore, "The status of Firestore must be reported.")
+	for _, name := range probes.Names() {
+		assert.Contains(t, response.Probes, name, "The status of every probe must be reported.")
+	}
+}
+
+func TestNewReadinessResponse(t *testing.T) {
+	names := []string{"curl", "ping", "traceroute"}
+	socketErr := errors.New("icmp.ListenPacket -> operation not permitted")
+	binaryErr := fmt.Errorf("%w: 'curlt'", probes.ErrMissingBinary)
+
+	tests := []struct {
+		name         string
+		firestoreErr error
+		failed       map[string]error
+		code         int
+	}{
+		{name: "ready", code: http.StatusOK},
+		{name: "socket", failed: map[string]error{"ping": socketErr, "traceroute": socketErr}, code: http.StatusOK},
+		{name: "binary", failed: map[string]error{"curl": binaryErr}, code: http.StatusServiceUnavailable},
+		{name: "firestore", firestoreErr: errors.New("unavailable"), code: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		response := newReadinessResponse(tt.firestoreErr, names, tt.failed)
+
+		assert.Equal(t, tt.code, response.Code, "Only Firestore and missing commands must make the instance unready: %s", tt.name)
+		assert.Len(t, response.Probes, len(names), "Every probe must be reported: %s", tt.name)
+		for _, name := range names {
+			if err, ok := tt.failed[name]; ok {
+				assert.Equal(t, err.Error(), response.Probes[name], "A failed check must be reported: %s", tt.name)
+			} else {
+				assert.Equal(t, "ok", response.Probes[name], "A passed check must be reported: %s", tt.name)
+			}
+		}
+	}
+}
+
+func TestCachedCheck(t *testing.T) {
+	var c cachedCheck
+	calls := 0
+	check := func(context.Context) error {
+		calls++
+		return fmt.Errorf("failure %d", calls)
+	}
+
+	first := c.run(context.Background(), check)
+	second := c.run(context.Background(), check)
+	assert.Equal(t, 1, calls, "A recent outcome must be reused.")
+	assert.Equal(t, first, second, "A recent outcome must be reused.")
+
+	c.checked = time.Now().Add(-readinessCacheTTL)
+	third := c.run(context.Background(), check)
+	assert.Equal(t, 2, calls, "An expired outcome must be checked again.")
+	assert.EqualError(t, third, "failure 2", "An expired outcome must be checked again.")
+}
+
+func TestMetrics(t *testing.T) {
+	observeProbe("metrics-test", tasks.OutcomeSuccess, 20*time.Millisecond)
+	observeProbe("metrics-test", tasks.OutcomeTimeout, time.Second)
+
+	w := serve(t, &Server{}, "/metrics")
+	body, _ := io.ReadAll(w.Body)
+
+	assert.Equal(t, http.StatusOK, w.Code, "The metrics must be served.")
+	for _, line := range []string{
+		`measurer_probe_runs_total{probe="metrics-test"} 2`,
+		`measurer_probe_failures_total{outcome="timeout",probe="metrics-test"} 1`,
+		`measurer_probe_duration_seconds_count{probe="metrics-test"} 2`,
+		`measurer_measurements_in_flight 0`,
+		`go_goroutines`,
+	} {
+		assert.True(t, strings.Contains(string(body), line), "The metrics must contain: %s", line)
+	}
+}
@@ -0,0 +1,220 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/rafikurnia/measurement-measurer/probes"
+	"github.com/rafikurnia/measurement-measurer/tasks"
+	"github.com/rafikurnia/measurement-measurer/utils/logger"
+)
+
+// maxConcurrentTargets bounds the number of targets measured at once.
+const maxConcurrentTargets = 8
+
+// maxMeasurementDuration bounds the measurement of every target of a task,
+// leaving the agent time to store the result before the scheduler gives up
+// on the request after 180 seconds. create-task keeps the timeout of the
+// tasks with many targets within it.
+const maxMeasurementDuration = 150 * time.Second
+
+// sampling describes how many times, and how often, a probe runs within a
+// single measurement.
+type sampling struct {
+	count    int
+	interval time.Duration
+	timeout  time.Duration
+}
+
+// measureTargets measures every target concurrently, at most
+// maxConcurrentTargets at a time. targetArguments maps each target to the
+// full arguments of the probe. The targets run in as many rounds as
+// maxConcurrentTargets allows, and the whole measurement is bounded by the
+// timeout of the sampling for each round, up to maxMeasurementDuration.
+func (s *Server) measureTargets(ctx context.Context, probe probes.Probe, name string, targetArguments map[string]string, plan *sampling) (map[string]*tasks.Measurement, error) {
+	rounds := (len(targetArguments) + maxConcurrentTargets - 1) / maxConcurrentTargets
+	deadline := time.Duration(rounds) * plan.timeout
+	if deadline > maxMeasurementDuration {
+		deadline = maxMeasurementDuration
+	}
+	ctx, cancel := context.WithTimeout(ctx, deadline)
+	defer cancel()
+
+	measurements := make(map[string]*tasks.Measurement, len(targetArguments))
+	var mu sync.Mutex
+	var firstErr error
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, maxConcurrentTargets)
+	for target, args := range targetArguments {
+		wg.Add(1)
+		go func(target, args string) {
+			defer wg.Done()
+			select {
+			case <-ctx.Done():
+				mu.Lock()
+				measurements[target] = &tasks.Measurement{
+					Outcome: tasks.OutcomeTimeout,
+					Error:   interruptedError(ctx.Err(), plan.timeout, 0),
+				}
+				mu.Unlock()
+				return
+			case slots <- struct{}{}:
+			}
+			defer func() { <-slots }()
+
+			m, err := s.measure(ctx, probe, name, args, plan)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("%s: %w", target, err)
+				}
+				return
+			}
+			measurements[target] = m
+		}(target, args)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return measurements, nil
+}
+
+// measure runs the probe as many times as the sampling asks for. The
+// samples start plan.interval apart, however long each of them takes; a
+// sample that overruns the interval delays the next one only until it ends.
+func (s *Server) measure(ctx context.Context, probe probes.Probe, name, args string, plan *sampling) (*tasks.Measurement, error) {
+	samples := make([]*tasks.Sample, 0, plan.count)
+	results := make([]probes.Result, 0, plan.count)
+	first := time.Now()
+loop:
+	for i := 0; i < plan.count; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				break loop
+			case <-time.After(time.Until(first.Add(time.Duration(i) * plan.interval))):
+			}
+		}
+
+		sample, result, err := s.runSample(ctx, probe, name, args, plan.timeout)
+		if err != nil {
+			return nil, fmt.Errorf("runSample -> %w", err)
+		}
+
+		samples = append(samples, sample)
+		if result != nil {
+			results = append(results, result)
+		}
+		if sample.Outcome == tasks.OutcomeTimeout {
+			break
+		}
+	}
+
+	if plan.count == 1 {
+		return &tasks.Measurement{
+			Outcome: samples[0].Outcome,
+			Error:   samples[0].Error,
+			Result:  samples[0].Result,
+		}, nil
+	}
+
+	statistics := tasks.NewSampleStatistics(samples, probes.Aggregate(results))
+	return &tasks.Measurement{
+		Outcome:    statistics.Outcome(),
+		Samples:    samples,
+		Statistics: statistics,
+	}, nil
+}
+
+// runSample runs the probe once and records its outcome. The returned
+// result is nil unless the probe succeeded. An error is only returned when
+// the result cannot be encoded.
+func (s *Server) runSample(ctx context.Context, probe probes.Probe, name, args string, timeout time.Duration) (*tasks.Sample, probes.Result, error) {
+	reqLogger := logger.FromContext(ctx)
+
+	sample := &tasks.Sample{StartTime: time.Now()}
+	spanCtx, span := tracer.Start(ctx, "probe.Run", trace.WithAttributes(
+		attribute.String("probe", name),
+		attribute.String("probe.arguments", args),
+	))
+	result, err := probe.Run(spanCtx, args)
+	sample.StopTime = time.Now()
+	endSpan(span, err)
+	defer func() { observeProbe(name, sample.Outcome, sample.StopTime.Sub(sample.StartTime)) }()
+	elapsed := float64(sample.StopTime.Sub(sample.StartTime)) / float64(time.Millisecond)
+
+	switch {
+	case ctx.Err() != nil:
+		// Whatever the probe collected before the deadline, or before the
+		// client disconnected, is incomplete, so only the outcome is
+		// recorded.
+		sample.Outcome = tasks.OutcomeTimeout
+		sample.Error = interruptedError(ctx.Err(), timeout, elapsed)
+		reqLogger.Log(logger.Entry{
+			Severity:  "WARN",
+			Message:   sample.Error.Message,
+			Component: "api",
+		})
+		return sample, nil, nil
+
+	case err != nil:
+		// A failed measurement is stored like any other, so that it can be
+		// told apart from a measurement that never ran.
+		reqLogger.Log(logger.Entry{
+			Severity:  "ERROR",
+			Message:   fmt.Errorf("probe.Run -> %w", err).Error(),
+			Component: "api",
+		})
+		sample.Outcome = tasks.OutcomeFailure
+		sample.Error = &tasks.MeasurementError{
+			Class:    probes.ErrorClass(err),
+			Message:  err.Error(),
+			ExitCode: probes.ExitCode(err),
+			Elapsed:  elapsed,
+		}
+		return sample, nil, nil
+	}
+
+	reqLogger.Log(logger.Entry{
+		Severity:  "INFO",
+		Message:   result.String(),
+		Component: "api",
+	})
+
+	sample.Outcome = tasks.OutcomeSuccess
+	sample.Result, err = probes.Encode(name, result)
+	if err != nil {
+		return nil, nil, fmt.Errorf("probes.Encode -> %w", err)
+	}
+	return sample, result, nil
+}
+
+// interruptedError describes a measurement cut short by its timeout, or
+// cancelled because the client disconnected.
+func interruptedError(err error, timeout time.Duration, elapsed float64) *tasks.MeasurementError {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return &tasks.MeasurementError{
+			Class:    "timeout",
+			Message:  fmt.Sprintf("The measurement timed out after %v", timeout),
+			ExitCode: -1,
+			Elapsed:  elapsed,
+		}
+	}
+	return &tasks.MeasurementError{
+		Class:    "cancelled",
+		Message:  "The measurement was cancelled as the client disconnected",
+		ExitCode: -1,
+		Elapsed:  elapsed,
+	}
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/rafikurnia/measurement-measurer/probes"
+	"github.com/rafikurnia/measurement-measurer/tasks"
+)
+
+type sleepResult struct {
+	Slept float64 `json:"slept_ms"`
+}
+
+func (r *sleepResult) String() string { return "slept" }
+
+// sleepProbe takes the given time to run, or until its context is done.
+type sleepProbe struct {
+	duration time.Duration
+}
+
+func (p *sleepProbe) Validate(args string) error { return nil }
+
+func (p *sleepProbe) Run(ctx context.Context, args string) (probes.Result, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(p.duration):
+	}
+	return &sleepResult{Slept: float64(p.duration / time.Millisecond)}, nil
+}
+
+func TestMeasureSpacesSamplesStartToStart(t *testing.T) {
+	s := &Server{}
+	plan := &sampling{count: 3, interval: 100 * time.Millisecond, timeout: 5 * time.Second}
+
+	m, err := s.measure(context.Background(), &sleepProbe{duration: 60 * time.Millisecond}, "sleep", "", plan)
+
+	assert.Nil(t, err, "The measurement must succeed.")
+	if assert.Len(t, m.Samples, 3, "Every sample must be taken.") {
+		for i := 1; i < len(m.Samples); i++ {
+			gap := m.Samples[i].StartTime.Sub(m.Samples[i-1].StartTime)
+			assert.InDelta(t, float64(plan.interval), float64(gap), float64(40*time.Millisecond), "The samples must start an interval apart: %v", gap)
+		}
+	}
+	assert.Equal(t, tasks.OutcomeSuccess, m.Outcome, "The measurement must be successful.")
+}
+
+func TestMeasureTargetsDeadlineCoversEveryRound(t *testing.T) {
+	s := &Server{}
+	plan := &sampling{count: 1, timeout: 150 * time.Millisecond}
+
+	// Twice as many targets as can run at once, each taking most of the
+	// timeout, so that the queued targets would time out with a deadline of
+	// a single timeout.
+	targetArguments := make(map[string]string)
+	for i := 0; i < 2*maxConcurrentTargets; i++ {
+		targetArguments[fmt.Sprintf("target-%d", i)] = ""
+	}
+
+	measurements, err := s.measureTargets(context.Background(), &sleepProbe{duration: 100 * time.Millisecond}, "sleep", targetArguments, plan)
+
+	assert.Nil(t, err, "The measurement must succeed.")
+	assert.Len(t, measurements, len(targetArguments), "Every target must be measured.")
+	for target, m := range measurements {
+		assert.Equal(t, tasks.OutcomeSuccess, m.Outcome, "A queued target must not time out: %s", target)
+	}
+}
+
+func TestMeasureTargetsRecordsCancellation(t *testing.T) {
+	s := &Server{}
+	plan := &sampling{count: 1, timeout: 5 * time.Second}
+
+	// The queued targets never start, and the running ones are cut short.
+	targetArguments := make(map[string]string)
+	for i := 0; i < 2*maxConcurrentTargets; i++ {
+		targetArguments[fmt.Sprintf("target-%d", i)] = ""
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	measurements, err := s.measureTargets(ctx, &sleepProbe{duration: time.Second}, "sleep", targetArguments, plan)
+
+	assert.Nil(t, err, "A cancelled measurement must be recorded.")
+	assert.Len(t, measurements, len(targetArguments), "Every target must be recorded.")
+	for target, m := range measurements {
+		assert.Equal(t, tasks.OutcomeTimeout, m.Outcome, "A cancelled target must be recorded as timed out: %s", target)
+		if assert.NotNil(t, m.Error, "A cancelled target must have an error: %s", target) {
+			assert.Equal(t, "cancelled", m.Error.Class, "The error must tell the cancellation from a timeout: %s", target)
+		}
+	}
+}
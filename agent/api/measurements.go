@@ -1,48 +1,71 @@
 package api
 
 import (
-	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"io/ioutil"
-	"log"
 	"net/http"
 	"os"
-	"os/exec"
 	"strconv"
-	"strings"
+	"sync/atomic"
 	"time"
 
-	"cloud.google.com/go/firestore"
-
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
 
+	"github.com/rafikurnia/measurement-measurer/probes"
 	"github.com/rafikurnia/measurement-measurer/tasks"
 	"github.com/rafikurnia/measurement-measurer/utils"
 	"github.com/rafikurnia/measurement-measurer/utils/logger"
 
-	hstat "github.com/tcnksm/go-httpstat"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	"golang.org/x/exp/maps"
 )
 
-var trace string
+// defaultProbeTimeout bounds a measurement when the task does not set its own
+// timeout, well within the 180 seconds the scheduler waits for a response.
+const defaultProbeTimeout = 60 * time.Second
+
+// storeTimeout bounds the storing of a measurement once the client has
+// disconnected.
+const storeTimeout = 30 * time.Second
+
+// detachedContext keeps the values of its parent, such as the span and the
+// logger of the request, but neither its deadline nor its cancellation.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+var (
+	instanceStart    = time.Now()
+	instanceRequests int64
+)
 
-func runMeasurement(ctx *gin.Context) {
+func (s *Server) runMeasurement(ctx *gin.Context) {
 	var bt, mbt, met, et int64
 	bt = time.Now().UnixNano() / int64(time.Millisecond)
 
-	projectID := os.Getenv("GOOGLE_CLOUD_PROJECT")
-	if projectID != "" {
-		traceHeader := ctx.Request.Header.Get("X-Cloud-Trace-Context")
-		traceParts := strings.Split(traceHeader, "/")
-		if len(traceParts) > 0 && len(traceParts[0]) > 0 {
-			trace = fmt.Sprintf("projects/%s/traces/%s", projectID, traceParts[0])
-		}
+	measurementsInFlight.Inc()
+	defer measurementsInFlight.Dec()
+
+	calibration := &probes.Calibration{
+		HandlerStart:     time.Now(),
+		InstanceStart:    instanceStart,
+		InstanceRequests: atomic.AddInt64(&instanceRequests, 1),
+	}
+	if st, err := time.Parse(time.RFC3339, ctx.GetHeader("X-CloudScheduler-ScheduleTime")); err == nil {
+		calibration.ScheduleTime = st
 	}
+	ctx.Request = ctx.Request.WithContext(probes.WithCalibration(ctx.Request.Context(), calibration))
+
+	reqLogger := logger.FromContext(ctx)
 
 	benchmarkID := ctx.Request.URL.Query().Get("benchmark_id")
 	benchmarkSeq := ctx.Request.URL.Query().Get("benchmark_sequence")
@@ -52,6 +75,9 @@ func runMeasurement(ctx *gin.Context) {
 
 	task := &struct {
 		ID string `json:"id"`
+		// Key identifies the execution, so that duplicate requests return
+		// the existing result instead of measuring again.
+		Key string `json:"key"`
 	}{}
 
 	defer func() {
@@ -62,18 +88,32 @@ func runMeasurement(ctx *gin.Context) {
 			MeasurementBeginningTime: mbt,
 			MeasurementEndingTime:    met,
 			EndingTime:               et,
+			ColdStart:                calibration.ColdStart(),
+		}
+		if mbt != 0 {
+			bench.OverheadBefore = mbt - bt
+		}
+		if met != 0 {
+			bench.OverheadAfter = et - met
+		}
+		if !calibration.ScheduleTime.IsZero() {
+			bench.ScheduleTime = calibration.ScheduleTime.UnixNano() / int64(time.Millisecond)
+		}
+		for _, d := range calibration.FirestoreReads() {
+			bench.FirestoreReadTime += d.Milliseconds()
+		}
+		for _, d := range calibration.FirestoreWrites() {
+			bench.FirestoreWriteTime += d.Milliseconds()
 		}
 
 		inJson, _ := json.Marshal(bench)
 
-		log.Println(logger.Entry{
-			// TaskID:            task.ID,
+		reqLogger.Log(logger.Entry{
 			Severity:          "INFO",
 			Message:           string(inJson),
 			Component:         "benchmark",
 			BenchmarkID:       bid,
 			BenchmarkSequence: bseq,
-			Trace:             trace,
 		})
 	}()
 
@@ -89,14 +129,12 @@ func runMeasurement(ctx *gin.Context) {
 				err = errors.New("unknown panic")
 			}
 
-			log.Println(logger.Entry{
-				// TaskID:            task.ID,
+			reqLogger.Log(logger.Entry{
 				Severity:          "CRITICAL",
 				Message:           err.Error(),
 				Component:         "panic",
 				BenchmarkID:       bid,
 				BenchmarkSequence: bseq,
-				Trace:             trace,
 			})
 			utils.Throws(ctx, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
 			return
@@ -104,12 +142,10 @@ func runMeasurement(ctx *gin.Context) {
 	}()
 
 	if err := ctx.ShouldBindBodyWith(task, binding.JSON); err != nil {
-		log.Println(logger.Entry{
-			// TaskID:    task.ID,
+		reqLogger.Log(logger.Entry{
 			Severity:  "ERROR",
 			Message:   fmt.Errorf("ctx.ShouldBindBodyWith -> %w", err).Error(),
 			Component: "api",
-			Trace:     trace,
 		})
 		utils.Throws(ctx, http.StatusBadRequest, err.Error())
 		return
@@ -117,18 +153,55 @@ func runMeasurement(ctx *gin.Context) {
 
 	if task.ID == "" {
 		err := errors.New("Missing task ID")
-		log.Println(logger.Entry{
-			// TaskID:    task.ID,
+		reqLogger.Log(logger.Entry{
 			Severity:  "ERROR",
 			Message:   err.Error(),
 			Component: "api",
-			Trace:     trace,
 		})
 		utils.Throws(ctx, http.StatusBadRequest, err.Error())
 		return
 	}
+	reqLogger.SetTaskID(task.ID)
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("task.id", task.ID))
 
-	metadata, _ := getTaskMetadata(ctx, task.ID)
+	if s.Firestore == nil {
+		msg := "The Firestore client is not available"
+		reqLogger.Log(logger.Entry{
+			Severity:  "ERROR",
+			Message:   msg,
+			Component: "firestore",
+		})
+		utils.Throws(ctx, http.StatusServiceUnavailable, msg)
+		return
+	}
+
+	// The metadata is read once, and kept up to date with what this request
+	// writes.
+	metadata, err := getTaskMetadata(ctx, s.Firestore, task.ID)
+	if err != nil {
+		reqLogger.Log(logger.Entry{
+			Severity:  "ERROR",
+			Message:   fmt.Errorf("getTaskMetadata -> %w", err).Error(),
+			Component: "firestore",
+		})
+		utils.Throws(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	key := executionKey(ctx, task.Key)
+	if key != "" {
+		existing, err := findExecution(ctx, s.Firestore, task.ID, os.Getenv("REGION"), key)
+		if err != nil {
+			reqLogger.Log(logger.Entry{
+				Severity:  "WARN",
+				Message:   fmt.Errorf("findExecution -> %w", err).Error(),
+				Component: "firestore",
+			})
+		} else if existing != nil {
+			respondExisting(ctx, key, existing)
+			return
+		}
+	}
 
 	mustDeleteScheduler := true
 	if metadata.Type == "one-off_as-soon-as-possible" {
@@ -140,12 +213,10 @@ func runMeasurement(ctx *gin.Context) {
 			deleteScheduler(ctx, task.ID)
 		}
 		msg := "The measurement is finished"
-		log.Println(logger.Entry{
-			// TaskID:    task.ID,
+		reqLogger.Log(logger.Entry{
 			Severity:  "INFO",
 			Message:   msg,
 			Component: "api",
-			Trace:     trace,
 		})
 		utils.Throws(ctx, http.StatusOK, msg)
 		return
@@ -154,258 +225,218 @@ func runMeasurement(ctx *gin.Context) {
 	if !metadata.Schedule.StartTime.IsZero() &&
 		metadata.Schedule.StartTime.After(time.Now()) {
 		msg := fmt.Sprintf("The StartTime is in the future: %v", metadata.Schedule.StartTime)
-		log.Println(logger.Entry{
-			// TaskID:    task.ID,
+		reqLogger.Log(logger.Entry{
 			Severity:  "INFO",
 			Message:   msg,
 			Component: "api",
-			Trace:     trace,
 		})
 		utils.Throws(ctx, http.StatusOK, msg)
 		return
 	}
 
-	if updateMeasurementStatus(ctx, task.ID, mustDeleteScheduler) {
+	if updateMeasurementStatus(ctx, s.Firestore, task.ID, metadata, mustDeleteScheduler) {
 		msg := "The job is done"
-		log.Println(logger.Entry{
-			// TaskID:    task.ID,
+		reqLogger.Log(logger.Entry{
 			Severity:  "INFO",
 			Message:   msg,
 			Component: "api",
-			Trace:     trace,
 		})
 		utils.Throws(ctx, http.StatusOK, msg)
 		return
 	}
 
 	seqStart := 0
-	metadata, _ = getTaskMetadata(ctx, task.ID)
 	for _, v := range maps.Values(metadata.NumberOfSequence) {
 		seqStart = seqStart * v
 	}
 
 	taskResult, err := tasks.NewTask()
 	if err != nil {
-		log.Println(logger.Entry{
-			// TaskID:    task.ID,
+		reqLogger.Log(logger.Entry{
 			Severity:  "WARN",
 			Message:   err.Error(),
 			Component: "task",
-			Trace:     trace,
 		})
 	}
 
+	taskResult.ExecutionKey = key
 	taskResult.MeasurementStartTime = time.Now()
 	mbt = taskResult.MeasurementStartTime.UnixNano() / int64(time.Millisecond)
 
 	if seqStart == 0 && metadata.Status == "scheduled" {
-		updateTaskMetadata(ctx, task.ID, []firestore.Update{
-			{Path: "Schedule.StartTime", Value: taskResult.MeasurementStartTime},
-			{Path: "Status", Value: "running"},
-		})
-	}
-
-	if metadata.Probe == "ping" || metadata.Probe == "traceroute" || metadata.Probe == "curl" {
-		var command string
-		if metadata.Probe == "ping" {
-			command = fmt.Sprintf("ping -c 1 %s", metadata.Arguments)
-		} else if metadata.Probe == "traceroute" {
-			command = fmt.Sprintf("traceroute %s", metadata.Arguments)
-		} else {
-			command = fmt.Sprintf("curlt %s", metadata.Arguments)
-		}
-
-		cmd := exec.Command("sh", "-c", command)
-		defer func() {
-			switch err := cmd.Process.Kill(); err {
-			case nil:
-				log.Println(logger.Entry{
-					// TaskID:    task.ID,
-					Severity:  "INFO",
-					Message:   fmt.Sprintf("The %s process has been terminated.", metadata.Probe),
-					Component: "api",
-					Trace:     trace,
-				})
-
-			case os.ErrProcessDone:
-				err = nil
-
-			default:
-				log.Println(logger.Entry{
-					// TaskID:    task.ID,
-					Severity:  "ERROR",
-					Message:   fmt.Errorf("cmd.Process.Kill -> %w", err).Error(),
-					Component: "api",
-					Trace:     trace,
-				})
-				return
-			}
-		}()
-
-		// Get the pipe for stdout
-		cmdReader, err := cmd.StdoutPipe()
-		if err != nil {
-			log.Println(logger.Entry{
-				// TaskID:    task.ID,
-				Severity:  "ERROR",
-				Message:   fmt.Errorf("cmd.StdoutPipe -> %w", err).Error(),
-				Component: "api",
-				Trace:     trace,
+		if err := markTaskRunning(ctx, s.Firestore, task.ID, taskResult.MeasurementStartTime); err != nil {
+			reqLogger.Log(logger.Entry{
+				Severity:  "WARN",
+				Message:   fmt.Errorf("markTaskRunning -> %w", err).Error(),
+				Component: "firestore",
 			})
-			utils.Throws(ctx, http.StatusInternalServerError, err.Error())
-			return
-		}
-
-		// Set stderr to also being sent to stdout
-		cmd.Stderr = cmd.Stdout
-
-		// Start executing the command
-		err = cmd.Start()
-		if err != nil {
-			log.Println(logger.Entry{
-				// TaskID:    task.ID,
-				Severity:  "ERROR",
-				Message:   fmt.Errorf("cmd.Start -> %w", err).Error(),
-				Component: "api",
-				Trace:     trace,
-			})
-			utils.Throws(ctx, http.StatusInternalServerError, err.Error())
-			return
 		}
+	}
 
-		scanner := bufio.NewScanner(cmdReader)
+	probe, ok := probes.Lookup(metadata.Probe)
+	if !ok {
+		err := fmt.Errorf("The measurement probe is not supported: '%s'", metadata.Probe)
+		reqLogger.Log(logger.Entry{
+			Severity:  "ERROR",
+			Message:   err.Error(),
+			Component: "api",
+		})
+		utils.Throws(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
 
-		var storage string
-		for scanner.Scan() {
-			t := scanner.Text()
-			log.Println(logger.Entry{
-				// TaskID:    task.ID,
-				Severity:  "INFO",
-				Message:   t,
-				Component: "api",
-				Trace:     trace,
-			})
-			storage += fmt.Sprintf("%s\n", t)
+	arguments := metadata.ArgumentsFor(os.Getenv("REGION"))
+	targetArguments := map[string]string{"": arguments}
+	if len(metadata.Targets) > 0 {
+		targetArguments = make(map[string]string, len(metadata.Targets))
+		for _, target := range metadata.Targets {
+			targetArguments[target] = probes.WithTarget(arguments, target)
 		}
-		cmd.Wait()
-
-		taskResult.Result = storage
-
-	} else if metadata.Probe == "httpstat" {
-		if !strings.HasPrefix(metadata.Arguments, "http://") &&
-			!strings.HasPrefix(metadata.Arguments, "https://") {
-
-			err := errors.New("The arguments must contain URL starts with either 'http://' or 'https://'.")
+	}
 
-			log.Println(logger.Entry{
-				// TaskID:    task.ID,
+	for _, args := range targetArguments {
+		if err := probe.Validate(args); err != nil {
+			reqLogger.Log(logger.Entry{
 				Severity:  "ERROR",
-				Message:   err.Error(),
+				Message:   fmt.Errorf("probe.Validate -> %w", err).Error(),
 				Component: "api",
-				Trace:     trace,
 			})
-			utils.Throws(ctx, http.StatusInternalServerError, err.Error())
+			code := http.StatusBadRequest
+			if errors.Is(err, probes.ErrTargetDenied) {
+				code = http.StatusForbidden
+			}
+			utils.Throws(ctx, code, err.Error())
 			return
 		}
+	}
 
-		req, err := http.NewRequest("GET", strings.ReplaceAll(metadata.Arguments, "\n", ""), nil)
-		if err != nil {
-			log.Println(logger.Entry{
-				// TaskID:    task.ID,
-				Severity:  "ERROR",
-				Message:   fmt.Errorf("http.NewRequest -> %w", err).Error(),
-				Component: "api",
-				Trace:     trace,
-			})
-			utils.Throws(ctx, http.StatusInternalServerError, err.Error())
-			return
-		}
+	timeout := defaultProbeTimeout
+	if metadata.Timeout > 0 {
+		timeout = time.Duration(metadata.Timeout) * time.Second
+	}
 
-		// The code below is mostly obtained from:
-		// https://medium.com/@deeeet/trancing-http-request-latency-in-golang-65b2463f548c
+	plan := &sampling{
+		count:    1,
+		interval: time.Duration(metadata.Interval * float64(time.Second)),
+		timeout:  timeout,
+	}
+	if metadata.Samples > 1 {
+		plan.count = metadata.Samples
+	}
 
-		// Create a httpstat powered context
-		var result hstat.Result
-		c := hstat.WithHTTPStat(req.Context(), &result)
-		req = req.WithContext(c)
+	// The probes are cancelled when the client disconnects, or once the
+	// deadline of the measurement has passed.
+	measurements, err := s.measureTargets(ctx.Request.Context(), probe, metadata.Probe, targetArguments, plan)
+	if err != nil {
+		reqLogger.Log(logger.Entry{
+			Severity:  "ERROR",
+			Message:   fmt.Errorf("measureTargets -> %w", err).Error(),
+			Component: "api",
+		})
+		utils.Throws(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
 
-		// Send request by default HTTP client
-		client := http.DefaultClient
-		res, err := client.Do(req)
-		if err != nil {
-			log.Println(logger.Entry{
-				// TaskID:    task.ID,
-				Severity:  "ERROR",
-				Message:   fmt.Errorf("client.Do -> %w", err).Error(),
-				Component: "api",
-				Trace:     trace,
-			})
-			utils.Throws(ctx, http.StatusInternalServerError, err.Error())
-			return
-		}
-		if _, err := io.Copy(ioutil.Discard, res.Body); err != nil {
-			log.Println(logger.Entry{
-				// TaskID:    task.ID,
-				Severity:  "ERROR",
-				Message:   fmt.Errorf("io.Copy -> %w", err).Error(),
-				Component: "api",
-				Trace:     trace,
-			})
-			utils.Throws(ctx, http.StatusInternalServerError, err.Error())
-			return
-		}
-		res.Body.Close()
+	// Once the client has disconnected, the measurements are recorded as
+	// cancelled, so that the sequence number of the region is not left
+	// without a result, under a context that is no longer cancelled.
+	var storeCtx context.Context = ctx
+	if err := ctx.Request.Context().Err(); err != nil {
+		reqLogger.Log(logger.Entry{
+			Severity:  "WARN",
+			Message:   fmt.Errorf("The client has disconnected: %w", err).Error(),
+			Component: "api",
+		})
+		var cancel context.CancelFunc
+		storeCtx, cancel = context.WithTimeout(detachedContext{ctx}, storeTimeout)
+		defer cancel()
+	}
 
-		outputs := fmt.Sprintf(
-			"DNS lookup: %d ms\n"+
-				"TCP connection: %d ms\n"+
-				"TLS handshake: %d ms\n"+
-				"Server processing: %d ms\n"+
-				"Content transfer: %d ms\n",
-			int(result.DNSLookup/time.Millisecond),
-			int(result.TCPConnection/time.Millisecond),
-			int(result.TLSHandshake/time.Millisecond),
-			int(result.ServerProcessing/time.Millisecond),
-			int(result.StartTransfer/time.Millisecond),
-		)
+	// The annotations are not part of the measurement, so they are looked up
+	// once sampling is over rather than within the deadline of the measurement.
+	annotateMeasurements(storeCtx, s.GeoIP, measurements)
 
-		taskResult.Result = outputs
+	if len(metadata.Targets) > 0 {
+		taskResult.Targets = measurements
+	} else {
+		taskResult.Measurement = *measurements[""]
 	}
 
-	taskResult.Sequence = metadata.NumberOfSequence[os.Getenv("REGION")] + 1
 	taskResult.MeasurementStopTime = time.Now()
 	met = taskResult.MeasurementStopTime.UnixNano() / int64(time.Millisecond)
 
-	err = uploadToFirestore(ctx, task.ID, taskResult)
+	metadata, err = commitMeasurement(storeCtx, s.Firestore, task.ID, taskResult, key)
+	if errors.Is(err, errDuplicateExecution) {
+		// A duplicate request committed first while this one was measuring.
+		existing, err := findExecution(storeCtx, s.Firestore, task.ID, os.Getenv("REGION"), key)
+		if err == nil && existing != nil {
+			respondExisting(ctx, key, existing)
+			return
+		}
+	}
 	if err != nil {
-		log.Println(logger.Entry{
-			// TaskID:    task.ID,
+		reqLogger.Log(logger.Entry{
 			Severity:  "ERROR",
-			Message:   fmt.Errorf("uploadToFirestore -> %w", err).Error(),
+			Message:   fmt.Errorf("commitMeasurement -> %w", err).Error(),
 			Component: "api",
-			Trace:     trace,
 		})
 		utils.Throws(ctx, http.StatusInternalServerError, err.Error())
 		return
 	}
-
-	updateTaskMetadata(ctx, task.ID, []firestore.Update{
-		{Path: fmt.Sprintf("NumberOfSequence.%s", os.Getenv("REGION")), Value: taskResult.Sequence},
-	})
+	// The sequence number is allocated by the commit, so only the entries
+	// logged from now on, such as the benchmark, carry it.
+	reqLogger.SetSequence(taskResult.Sequence)
 
 	data, err := json.Marshal(taskResult)
 	if err != nil {
-		log.Println(logger.Entry{
-			// TaskID:    task.ID,
+		reqLogger.Log(logger.Entry{
 			Severity:  "ERROR",
 			Message:   fmt.Errorf("json.Marshal -> %w", err).Error(),
 			Component: "api",
-			Trace:     trace,
 		})
 		utils.Throws(ctx, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	updateMeasurementStatus(ctx, task.ID, mustDeleteScheduler)
+	if isScheduleOver(metadata) && mustDeleteScheduler {
+		deleteScheduler(storeCtx, task.ID)
+	}
+	utils.Throws(ctx, http.StatusOK, string(data))
+}
+
+// executionKey identifies a measurement request: the key of the request
+// body or of the Idempotency-Key header, or else the time Cloud Scheduler
+// fired the job. It is empty when the request cannot be identified.
+func executionKey(ctx *gin.Context, key string) string {
+	if key != "" {
+		return key
+	}
+	if key := ctx.GetHeader("Idempotency-Key"); key != "" {
+		return key
+	}
+	if scheduleTime := ctx.GetHeader("X-CloudScheduler-ScheduleTime"); scheduleTime != "" {
+		return "schedule:" + scheduleTime
+	}
+	return ""
+}
+
+// respondExisting answers a duplicate request with the result stored for
+// its execution key.
+func respondExisting(ctx *gin.Context, key string, existing map[string]interface{}) {
+	reqLogger := logger.FromContext(ctx)
+	if sequence, ok := existing["Sequence"].(int64); ok {
+		reqLogger.SetSequence(int(sequence))
+	}
+	reqLogger.Log(logger.Entry{
+		Severity:  "INFO",
+		Message:   fmt.Sprintf("The measurement has already been executed: '%s'", key),
+		Component: "api",
+	})
+
+	data, err := json.Marshal(existing)
+	if err != nil {
+		utils.Throws(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
 	utils.Throws(ctx, http.StatusOK, string(data))
 }
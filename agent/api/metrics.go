@@ -0,0 +1,53 @@
+package api
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/rafikurnia/measurement-measurer/tasks"
+)
+
+// The metrics are registered with the default registry, which also exports
+// the metrics of the Go runtime and of the process.
+var (
+	probeRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "measurer_probe_runs_total",
+		Help: "Number of times a probe has run.",
+	}, []string{"probe"})
+
+	probeFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "measurer_probe_failures_total",
+		Help: "Number of probe runs that failed or timed out, by outcome.",
+	}, []string{"probe", "outcome"})
+
+	probeDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "measurer_probe_duration_seconds",
+		Help:    "Duration of probe runs.",
+		Buckets: prometheus.ExponentialBuckets(0.005, 2, 15),
+	}, []string{"probe"})
+
+	firestoreDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "measurer_firestore_duration_seconds",
+		Help:    "Latency of Firestore reads and writes.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	measurementsInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "measurer_measurements_in_flight",
+		Help: "Number of measurement requests being handled.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(probeRuns, probeFailures, probeDuration, firestoreDuration, measurementsInFlight)
+}
+
+// observeProbe records a probe run that ended with the given outcome.
+func observeProbe(probe, outcome string, d time.Duration) {
+	probeRuns.WithLabelValues(probe).Inc()
+	probeDuration.WithLabelValues(probe).Observe(d.Seconds())
+	if outcome != tasks.OutcomeSuccess {
+		probeFailures.WithLabelValues(probe, outcome).Inc()
+	}
+}
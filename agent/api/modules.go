@@ -0,0 +1,69 @@
+package api
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/rafikurnia/measurement-measurer/probes"
+)
+
+// ProbeModule is a preset of the /probe endpoint, like a module of
+// blackbox_exporter: the probe to run and the arguments that go before the
+// target. Callers choose a module by name and cannot pass arguments of
+// their own.
+type ProbeModule struct {
+	Probe     string `yaml:"probe"`
+	Arguments string `yaml:"arguments"`
+}
+
+// DefaultProbeModules makes every probe available as a module of the same
+// name, without arguments.
+func DefaultProbeModules() map[string]ProbeModule {
+	modules := make(map[string]ProbeModule)
+	for _, name := range probes.Names() {
+		modules[name] = ProbeModule{Probe: name}
+	}
+	return modules
+}
+
+// LoadProbeModules reads the modules from a YAML file such as:
+//
+//	modules:
+//	  icmp:
+//	    probe: ping
+//	    arguments: -c 3
+//	  http_2xx:
+//	    probe: curl
+//	    arguments: --max-time 5
+//
+// The default modules are returned when path is empty. The arguments are
+// validated along with the target of every request.
+func LoadProbeModules(path string) (map[string]ProbeModule, error) {
+	if path == "" {
+		return DefaultProbeModules(), nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("os.ReadFile -> %w", err)
+	}
+
+	var config struct {
+		Modules map[string]ProbeModule `yaml:"modules"`
+	}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("yaml.Unmarshal -> %w", err)
+	}
+
+	if config.Modules == nil {
+		config.Modules = make(map[string]ProbeModule)
+	}
+	for name, module := range config.Modules {
+		if _, ok := probes.Lookup(module.Probe); !ok {
+			return nil, fmt.Errorf("The measurement probe of module '%s' is not supported: '%s'", name, module.Probe)
+		}
+	}
+	return config.Modules, nil
+}
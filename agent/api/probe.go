@@ -0,0 +1,203 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/rafikurnia/measurement-measurer/probes"
+	"github.com/rafikurnia/measurement-measurer/tasks"
+	"github.com/rafikurnia/measurement-measurer/utils/logger"
+)
+
+// scrapeTimeoutOffset is subtracted from the scrape timeout sent by
+// Prometheus, so that the response arrives before Prometheus gives up, as
+// blackbox_exporter does.
+const scrapeTimeoutOffset = 500 * time.Millisecond
+
+var invalidMetricChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// probe runs a probe once against a target and responds with its outcome in
+// the Prometheus text format, like the /probe endpoint of blackbox_exporter.
+// The module parameter names one of the configured modules, which sets the
+// probe and its arguments. Nothing is stored in Firestore.
+func (s *Server) probe(ctx *gin.Context) {
+	reqLogger := logger.FromContext(ctx)
+
+	moduleName := ctx.Query("module")
+	target := ctx.Query("target")
+	if moduleName == "" || target == "" {
+		ctx.String(http.StatusBadRequest, "The module and target parameters are required")
+		return
+	}
+
+	module, ok := s.Modules[moduleName]
+	if !ok {
+		ctx.String(http.StatusBadRequest, fmt.Sprintf("The module is not configured: '%s'", moduleName))
+		return
+	}
+	name := module.Probe
+	probe, ok := probes.Lookup(name)
+	if !ok {
+		ctx.String(http.StatusBadRequest, fmt.Sprintf("The measurement probe is not supported: '%s'", name))
+		return
+	}
+
+	args := probes.WithTarget(module.Arguments, target)
+	if err := probe.Validate(args); err != nil {
+		code := http.StatusBadRequest
+		if errors.Is(err, probes.ErrTargetDenied) {
+			code = http.StatusForbidden
+		}
+		ctx.String(code, err.Error())
+		return
+	}
+
+	probeCtx, cancel := context.WithTimeout(ctx.Request.Context(), scrapeTimeout(ctx))
+	defer cancel()
+
+	spanCtx, span := tracer.Start(probeCtx, "probe.Run", trace.WithAttributes(
+		attribute.String("probe", name),
+		attribute.String("probe.arguments", args),
+	))
+	start := time.Now()
+	result, err := probe.Run(spanCtx, args)
+	duration := time.Since(start)
+	endSpan(span, err)
+
+	if errors.Is(err, probes.ErrTargetDenied) {
+		ctx.String(http.StatusForbidden, err.Error())
+		return
+	}
+
+	outcome := tasks.OutcomeSuccess
+	switch {
+	case errors.Is(probeCtx.Err(), context.DeadlineExceeded):
+		outcome = tasks.OutcomeTimeout
+	case err != nil:
+		outcome = tasks.OutcomeFailure
+	}
+	observeProbe(name, outcome, duration)
+
+	if outcome != tasks.OutcomeSuccess {
+		reqLogger.Log(logger.Entry{
+			Severity:  "WARN",
+			Message:   fmt.Sprintf("The probe of '%s' with %s ended with %s: %v", target, name, outcome, err),
+			Component: "probe",
+		})
+	}
+
+	registry := prometheus.NewRegistry()
+	setGauge(registry, "probe_success", "Whether the probe succeeded.", boolToFloat(outcome == tasks.OutcomeSuccess))
+	setGauge(registry, "probe_duration_seconds", "How long the probe took to complete in seconds.", duration.Seconds())
+
+	if outcome == tasks.OutcomeSuccess {
+		if lr, ok := result.(probes.LatencyResult); ok {
+			if ms, ok := lr.Latency(); ok {
+				setGauge(registry, "probe_latency_seconds", "The headline latency measured by the probe in seconds.", ms/1000)
+			}
+		}
+
+		encoded, err := probes.Encode(name, result)
+		if err != nil {
+			reqLogger.Log(logger.Entry{
+				Severity:  "ERROR",
+				Message:   fmt.Errorf("probes.Encode -> %w", err).Error(),
+				Component: "probe",
+			})
+		}
+
+		// The fields identifying the result are not measurements.
+		delete(encoded, "probe")
+		delete(encoded, "schema_version")
+
+		gauges := make(map[string]float64)
+		collectGauges(metricName("probe", name), encoded, gauges)
+		for metric, value := range gauges {
+			setGauge(registry, metric, "A field of the result of the probe.", value)
+		}
+	}
+
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(ctx.Writer, ctx.Request)
+}
+
+// scrapeTimeout returns the time left to the probe: the scrape timeout of
+// Prometheus less scrapeTimeoutOffset, but never more than
+// defaultProbeTimeout.
+func scrapeTimeout(ctx *gin.Context) time.Duration {
+	seconds, err := strconv.ParseFloat(ctx.GetHeader("X-Prometheus-Scrape-Timeout-Seconds"), 64)
+	if err != nil || seconds <= 0 {
+		return defaultProbeTimeout
+	}
+
+	timeout := time.Duration(seconds*float64(time.Second)) - scrapeTimeoutOffset
+	if timeout <= 0 {
+		timeout = time.Duration(seconds * float64(time.Second))
+	}
+	if timeout > defaultProbeTimeout {
+		timeout = defaultProbeTimeout
+	}
+	return timeout
+}
+
+// collectGauges adds a gauge for every number and boolean of an encoded
+// result, named after its path. Lists are left out, since their length
+// varies between runs. The keys are walked in sorted order, and when two
+// paths map to the same name, the gauge of the first one is kept.
+func collectGauges(prefix string, v interface{}, gauges map[string]float64) {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(x))
+		for key := range x {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			collectGauges(metricName(prefix, key), x[key], gauges)
+		}
+	case float64:
+		if _, ok := gauges[prefix]; !ok {
+			gauges[prefix] = x
+		}
+	case bool:
+		if _, ok := gauges[prefix]; !ok {
+			gauges[prefix] = boolToFloat(x)
+		}
+	}
+}
+
+// metricName joins the parts of a metric name, replacing the characters
+// that Prometheus does not allow.
+func metricName(prefix, name string) string {
+	return prefix + "_" + invalidMetricChars.ReplaceAllString(name, "_")
+}
+
+// setGauge registers a gauge holding value. A gauge whose name is already
+// taken is left out.
+func setGauge(registry *prometheus.Registry, name, help string, value float64) {
+	g := prometheus.NewGauge(prometheus.GaugeOpts{Name: name, Help: help})
+	if err := registry.Register(g); err != nil {
+		return
+	}
+	g.Set(value)
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
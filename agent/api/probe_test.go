@@ -0,0 +1,160 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/rafikurnia/measurement-measurer/probes"
+)
+
+func TestMetricName(t *testing.T) {
+	tests := []struct {
+		prefix, name, want string
+	}{
+		{prefix: "probe", name: "ping", want: "probe_ping"},
+		{prefix: "probe_ping", name: "rtt", want: "probe_ping_rtt"},
+		{prefix: "probe_http", name: "content-length", want: "probe_http_content_length"},
+		{prefix: "probe_dns", name: "time.ms/avg", want: "probe_dns_time_ms_avg"},
+		{prefix: "probe_tls", name: "ünïcode", want: "probe_tls__n_code"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, metricName(tt.prefix, tt.name), "The invalid characters must be replaced: %s", tt.name)
+	}
+}
+
+func TestCollectGauges(t *testing.T) {
+	result := map[string]interface{}{
+		"received": true,
+		"lost":     false,
+		"rtt": map[string]interface{}{
+			"min_ms": 1.5,
+			"max_ms": 2.5,
+		},
+		"packets": []interface{}{map[string]interface{}{"rtt_ms": 1.5}},
+		"address": "192.0.2.1",
+		"a-b":     1.0,
+		"a_b":     2.0,
+	}
+
+	gauges := make(map[string]float64)
+	collectGauges("probe_ping", result, gauges)
+
+	assert.Equal(t, map[string]float64{
+		"probe_ping_received":   1,
+		"probe_ping_lost":       0,
+		"probe_ping_rtt_min_ms": 1.5,
+		"probe_ping_rtt_max_ms": 2.5,
+		"probe_ping_a_b":        1,
+	}, gauges, "Numbers and booleans must be collected, and lists and strings left out.")
+
+	for i := 0; i < 20; i++ {
+		again := make(map[string]float64)
+		collectGauges("probe_ping", result, again)
+		assert.Equal(t, gauges, again, "The gauge kept on a name collision must not change between runs.")
+	}
+}
+
+func TestScrapeTimeout(t *testing.T) {
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{header: "", want: defaultProbeTimeout},
+		{header: "not a number", want: defaultProbeTimeout},
+		{header: "-5", want: defaultProbeTimeout},
+		{header: "10", want: 10*time.Second - scrapeTimeoutOffset},
+		{header: "2.5", want: 2 * time.Second},
+		{header: "0.25", want: 250 * time.Millisecond},
+		{header: "3600", want: defaultProbeTimeout},
+	}
+
+	gin.SetMode(gin.TestMode)
+	for _, tt := range tests {
+		ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+		ctx.Request = httptest.NewRequest(http.MethodGet, "/probe", nil)
+		if tt.header != "" {
+			ctx.Request.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", tt.header)
+		}
+
+		assert.Equal(t, tt.want, scrapeTimeout(ctx), "The timeout must follow the scrape timeout: %q", tt.header)
+	}
+}
+
+func TestProbeModules(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.Nil(t, err, "The listener must be opened.") {
+		return
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	s := &Server{Modules: map[string]ProbeModule{"tcp_connect": {Probe: "tcp", Arguments: "-c 2 -i 0.01"}}}
+	query := url.Values{"module": {"tcp_connect"}, "target": {listener.Addr().String()}}
+
+	w := serve(t, s, "/probe?"+query.Encode())
+	body := w.Body.String()
+	assert.Equal(t, http.StatusOK, w.Code, "A configured module must be run.")
+	assert.True(t, strings.Contains(body, "probe_success 1"), "The probe must succeed: %s", body)
+	assert.True(t, strings.Contains(body, "probe_tcp_"), "The fields of the result must be exported: %s", body)
+	assert.False(t, strings.Contains(body, "schema_version"), "The schema version must not be exported: %s", body)
+
+	query.Set("module", "tcp")
+	w = serve(t, s, "/probe?"+query.Encode())
+	assert.Equal(t, http.StatusBadRequest, w.Code, "A probe that is not a configured module must be rejected.")
+
+	query.Set("module", "tcp_connect")
+	query.Set("target", "-c 100 "+listener.Addr().String())
+	w = serve(t, s, "/probe?"+query.Encode())
+	assert.Equal(t, http.StatusBadRequest, w.Code, "A target must not add arguments to the module.")
+}
+
+func TestProbeDeniedTarget(t *testing.T) {
+	probes.SetTargetPolicy(probes.DefaultTargetPolicy())
+	defer probes.SetTargetPolicy(nil)
+
+	s := &Server{Modules: map[string]ProbeModule{"tcp_connect": {Probe: "tcp"}}}
+	query := url.Values{"module": {"tcp_connect"}, "target": {"169.254.169.254:80"}}
+
+	w := serve(t, s, "/probe?"+query.Encode())
+	assert.Equal(t, http.StatusForbidden, w.Code, "A target denied by the policy must be forbidden.")
+}
+
+func TestLoadProbeModules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "modules.yml")
+	os.WriteFile(path, []byte("modules:\n  icmp:\n    probe: ping\n    arguments: -c 3\n  http_2xx:\n    probe: curl\n"), 0o600)
+
+	modules, err := LoadProbeModules(path)
+	assert.Nil(t, err, "A valid file must be loaded.")
+	assert.Equal(t, map[string]ProbeModule{
+		"icmp":     {Probe: "ping", Arguments: "-c 3"},
+		"http_2xx": {Probe: "curl"},
+	}, modules, "The modules must be read from the file.")
+
+	os.WriteFile(path, []byte("modules:\n  sctp:\n    probe: sctp\n"), 0o600)
+	_, err = LoadProbeModules(path)
+	assert.NotNil(t, err, "A module of an unknown probe must be rejected.")
+
+	modules, err = LoadProbeModules("")
+	assert.Nil(t, err, "The default modules must be returned without a file.")
+	assert.Equal(t, ProbeModule{Probe: "ping"}, modules["ping"], "Every probe must be a default module.")
+}
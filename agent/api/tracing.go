@@ -0,0 +1,54 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/rafikurnia/measurement-measurer/utils/logger"
+	"github.com/rafikurnia/measurement-measurer/utils/tracing"
+)
+
+var tracer = otel.Tracer("github.com/rafikurnia/measurement-measurer/api")
+
+// requestScope starts the span of a request and attaches a logger that
+// identifies the request to its context.
+func requestScope(ctx *gin.Context) {
+	reqCtx := tracing.Extract(ctx.Request.Context(), ctx.Request.Header)
+	reqCtx, span := tracer.Start(reqCtx, ctx.Request.Method+" "+ctx.FullPath(),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(semconv.HTTPServerAttributesFromHTTPRequest("", ctx.FullPath(), ctx.Request)...),
+	)
+	defer span.End()
+
+	var traceName, spanID string
+	if projectID := os.Getenv("GOOGLE_CLOUD_PROJECT"); projectID != "" && span.SpanContext().IsValid() {
+		traceName = fmt.Sprintf("projects/%s/traces/%s", projectID, span.SpanContext().TraceID())
+		spanID = span.SpanContext().SpanID().String()
+	}
+	reqCtx = logger.WithLogger(reqCtx, logger.New(traceName, spanID, os.Getenv("REGION")))
+	ctx.Request = ctx.Request.WithContext(reqCtx)
+
+	ctx.Next()
+
+	span.SetAttributes(semconv.HTTPStatusCodeKey.Int(ctx.Writer.Status()))
+	if ctx.Writer.Status() >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(ctx.Writer.Status()))
+	}
+}
+
+// endSpan ends the span, marking it as failed if err is not nil.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -2,6 +2,9 @@ package api
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
 	"strconv"
@@ -11,26 +14,54 @@ import (
 
 	"cloud.google.com/go/firestore"
 
-	firebase "firebase.google.com/go"
-
 	"github.com/fatih/structs"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/rafikurnia/measurement-measurer/probes"
 	"github.com/rafikurnia/measurement-measurer/tasks"
 )
 
 var firestoreCollectionName string
 
-func getTaskMetadata(ctx context.Context, taskID string) (*tasks.TaskMetadata, error) {
-	app, err := firebase.NewApp(ctx, nil)
-	if err != nil {
-		return nil, fmt.Errorf("firebase.NewApp -> %w", err)
-	}
+// errDuplicateExecution is returned when a measurement with the same
+// execution key has already been committed.
+var errDuplicateExecution = errors.New("The measurement has already been executed")
 
-	client, err := app.Firestore(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("app.Firestore -> %w", err)
+// recordFirestoreRead adds the latency of a read started at start to the
+// calibration of the request, if any, and to the metrics.
+func recordFirestoreRead(ctx context.Context, start time.Time) {
+	d := time.Since(start)
+	probes.CalibrationFrom(ctx).AddFirestoreRead(d)
+	firestoreDuration.WithLabelValues("read").Observe(d.Seconds())
+}
+
+// recordFirestoreWrite adds the latency of a write started at start to the
+// calibration of the request, if any, and to the metrics.
+func recordFirestoreWrite(ctx context.Context, start time.Time) {
+	d := time.Since(start)
+	probes.CalibrationFrom(ctx).AddFirestoreWrite(d)
+	firestoreDuration.WithLabelValues("write").Observe(d.Seconds())
+}
+
+// pingFirestore checks that Firestore can be reached, by reading a document
+// that need not exist.
+func pingFirestore(ctx context.Context, client *firestore.Client) error {
+	_, err := client.Collection(firestoreCollectionName).Doc("readyz").Get(ctx)
+	if err != nil && status.Code(err) != codes.NotFound {
+		return fmt.Errorf("client.Collection.Get -> %w", err)
 	}
-	defer client.Close()
+	return nil
+}
+
+func getTaskMetadata(ctx context.Context, client *firestore.Client, taskID string) (_ *tasks.TaskMetadata, err error) {
+	ctx, span := tracer.Start(ctx, "getTaskMetadata", trace.WithAttributes(attribute.String("task.id", taskID)))
+	defer func() { endSpan(span, err) }()
+	defer recordFirestoreRead(ctx, time.Now())
 
 	dsnap, err := client.Collection(firestoreCollectionName).Doc(taskID).Get(ctx)
 	if err != nil {
@@ -49,74 +80,234 @@ func getTaskMetadata(ctx context.Context, taskID string) (*tasks.TaskMetadata, e
 	return testData, nil
 }
 
-func updateTaskMetadata(ctx context.Context, taskID string, data []firestore.Update) error {
-	app, err := firebase.NewApp(ctx, nil)
+// isScheduleOver reports whether the region has measured at least once and
+// no further measurement is due.
+func isScheduleOver(metadata *tasks.TaskMetadata) bool {
+	return metadata.NumberOfSequence[os.Getenv("REGION")] > 0 &&
+		(metadata.Schedule.StopTime.IsZero() || metadata.Schedule.StopTime.Before(time.Now()))
+}
+
+// allRegionsMeasured reports whether every region has measured at least
+// once.
+func allRegionsMeasured(metadata *tasks.TaskMetadata) bool {
+	seqStop := 1
+	for _, v := range maps.Values(metadata.NumberOfSequence) {
+		seqStop = seqStop * v
+	}
+	return seqStop != 0
+}
+
+// finishedUpdates returns the updates marking the task as finished when the
+// schedule is over and every region has measured, or nil.
+func finishedUpdates(metadata *tasks.TaskMetadata) []firestore.Update {
+	if !isScheduleOver(metadata) || !allRegionsMeasured(metadata) || metadata.Status != "running" {
+		return nil
+	}
+	return []firestore.Update{
+		{Path: "Schedule.StopTime", Value: time.Now()},
+		{Path: "Status", Value: "finished"},
+	}
+}
+
+// updateMeasurementStatus deletes the scheduler of the region and marks the
+// task as finished once every region has measured, when the schedule is
+// over. It reports whether the schedule is over.
+func updateMeasurementStatus(ctx context.Context, client *firestore.Client, t string, metadata *tasks.TaskMetadata, mustDeleteScheduler bool) bool {
+	if !isScheduleOver(metadata) {
+		return false
+	}
+
+	if mustDeleteScheduler {
+		deleteScheduler(ctx, t)
+	}
+
+	finishTask(ctx, client, t)
+	return true
+}
+
+// finishTask marks the task as finished, in a transaction so that it is
+// based on the latest sequence numbers of every region.
+func finishTask(ctx context.Context, client *firestore.Client, taskID string) error {
+	defer recordFirestoreWrite(ctx, time.Now())
+
+	ref := client.Collection(firestoreCollectionName).Doc(taskID)
+	err := client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		metadata, err := getTaskMetadataInTransaction(tx, ref)
+		if err != nil {
+			return err
+		}
+		if updates := finishedUpdates(metadata); updates != nil {
+			return tx.Update(ref, updates)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("client.RunTransaction -> %w", err)
+	}
+	return nil
+}
+
+// markTaskRunning moves a scheduled task to running, in a transaction so
+// that only the first region to measure sets its start time.
+func markTaskRunning(ctx context.Context, client *firestore.Client, taskID string, start time.Time) error {
+	defer recordFirestoreWrite(ctx, time.Now())
+
+	ref := client.Collection(firestoreCollectionName).Doc(taskID)
+	err := client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		metadata, err := getTaskMetadataInTransaction(tx, ref)
+		if err != nil {
+			return err
+		}
+		if metadata.Status != "scheduled" {
+			return nil
+		}
+		return tx.Update(ref, []firestore.Update{
+			{Path: "Schedule.StartTime", Value: start},
+			{Path: "Status", Value: "running"},
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("client.RunTransaction -> %w", err)
+	}
+	return nil
+}
+
+// executionRef returns the document recording which sequence number the
+// measurement of a task in a region with the given execution key was
+// stored under. The documents live in their own collection, since every
+// subcollection of a task holds the results of a region.
+func executionRef(client *firestore.Client, taskID, region, key string) *firestore.DocumentRef {
+	h := sha256.Sum256([]byte(taskID + "\x00" + region + "\x00" + key))
+	return client.Collection(firestoreCollectionName + "_executions").Doc(hex.EncodeToString(h[:]))
+}
+
+// findExecution returns the result stored for the execution key, or nil if
+// there is none.
+func findExecution(ctx context.Context, client *firestore.Client, taskID, region, key string) (map[string]interface{}, error) {
+	defer recordFirestoreRead(ctx, time.Now())
+
+	dsnap, err := executionRef(client, taskID, region, key).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
 	if err != nil {
-		return fmt.Errorf("firebase.NewApp -> %w", err)
+		return nil, fmt.Errorf("executionRef.Get -> %w", err)
 	}
 
-	client, err := app.Firestore(ctx)
+	sequence, err := dsnap.DataAt("Sequence")
 	if err != nil {
-		return fmt.Errorf("app.Firestore -> %w", err)
+		return nil, fmt.Errorf("dsnap.DataAt -> %w", err)
+	}
+	seq, ok := sequence.(int64)
+	if !ok {
+		return nil, fmt.Errorf("The sequence of the execution is not a number: %v", sequence)
 	}
-	defer client.Close()
 
-	_, err = client.Collection(firestoreCollectionName).Doc(taskID).Update(ctx, data)
+	dsnap, err = client.Collection(firestoreCollectionName).Doc(taskID).Collection(region).Doc(strconv.FormatInt(seq, 10)).Get(ctx)
 	if err != nil {
-		return fmt.Errorf("client.Collection.Update -> %w", err)
+		return nil, fmt.Errorf("client.Collection.Get -> %w", err)
 	}
 
-	return nil
+	data := dsnap.Data()
+	data["Region"] = region
+	data["Sequence"] = seq
+	return data, nil
 }
 
-func updateMeasurementStatus(ctx context.Context, t string, mustDeleteScheduler bool) bool {
-	metadata, _ := getTaskMetadata(ctx, t)
-	if metadata.NumberOfSequence[os.Getenv("REGION")] > 0 {
-		if metadata.Schedule.StopTime.IsZero() || (!metadata.Schedule.StopTime.IsZero() &&
-			metadata.Schedule.StopTime.Before(time.Now())) {
+// commitMeasurement allocates the next sequence number of the region,
+// stores the result under it and marks the task as finished if it was the
+// last measurement, all in a single transaction. Concurrent or retried
+// invocations therefore never overwrite each other's results. When key is
+// not empty, errDuplicateExecution is returned if a measurement with the
+// same key was already committed. It returns the metadata as committed.
+func commitMeasurement(ctx context.Context, client *firestore.Client, taskID string, t *tasks.Task, key string) (_ *tasks.TaskMetadata, err error) {
+	ctx, span := tracer.Start(ctx, "commitMeasurement", trace.WithAttributes(
+		attribute.String("task.id", taskID),
+		attribute.String("region", t.Region),
+	))
+	defer func() { endSpan(span, err) }()
+	defer recordFirestoreWrite(ctx, time.Now())
 
-			if mustDeleteScheduler {
-				deleteScheduler(ctx, t)
-			}
+	ref := client.Collection(firestoreCollectionName).Doc(taskID)
+
+	var committed *tasks.TaskMetadata
+	err = client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		metadata, err := getTaskMetadataInTransaction(tx, ref)
+		if err != nil {
+			return err
+		}
 
-			seqStop := 1
-			metadata, _ = getTaskMetadata(ctx, t)
-			for _, v := range maps.Values(metadata.NumberOfSequence) {
-				seqStop = seqStop * v
+		var execution *firestore.DocumentRef
+		if key != "" {
+			execution = executionRef(client, taskID, t.Region, key)
+			_, err := tx.Get(execution)
+			if err == nil {
+				return errDuplicateExecution
 			}
+			if status.Code(err) != codes.NotFound {
+				return fmt.Errorf("tx.Get -> %w", err)
+			}
+		}
+
+		t.Sequence = metadata.NumberOfSequence[t.Region] + 1
+		metadata.NumberOfSequence[t.Region] = t.Sequence
+
+		data := structs.Map(t)
+		delete(data, "Region")
+		delete(data, "Sequence")
+
+		// Create fails if the document exists, rather than overwriting it.
+		if err := tx.Create(ref.Collection(t.Region).Doc(strconv.Itoa(t.Sequence)), data); err != nil {
+			return fmt.Errorf("tx.Create -> %w", err)
+		}
+
+		updates := []firestore.Update{
+			{Path: fmt.Sprintf("NumberOfSequence.%s", t.Region), Value: t.Sequence},
+		}
+		if finished := finishedUpdates(metadata); finished != nil {
+			updates = append(updates, finished...)
+			metadata.Status = "finished"
+		}
+		if err := tx.Update(ref, updates); err != nil {
+			return fmt.Errorf("tx.Update -> %w", err)
+		}
 
-			if seqStop != 0 && metadata.Status == "running" {
-				updateTaskMetadata(ctx, t, []firestore.Update{
-					{Path: "Schedule.StopTime", Value: time.Now()},
-					{Path: "Status", Value: "finished"},
-				})
+		if execution != nil {
+			if err := tx.Create(execution, map[string]interface{}{
+				"TaskID":   taskID,
+				"Region":   t.Region,
+				"Key":      key,
+				"Sequence": t.Sequence,
+			}); err != nil {
+				return fmt.Errorf("tx.Create -> %w", err)
 			}
-			return true
 		}
-	}
-	return false
-}
 
-func uploadToFirestore(ctx context.Context, taskID string, t *tasks.Task) error {
-	app, err := firebase.NewApp(ctx, nil)
+		committed = metadata
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("firebase.NewApp -> %w", err)
+		return nil, fmt.Errorf("client.RunTransaction -> %w", err)
 	}
+	span.SetAttributes(attribute.Int("sequence", t.Sequence))
+	return committed, nil
+}
 
-	client, err := app.Firestore(ctx)
+// getTaskMetadataInTransaction reads the metadata of a task within a
+// transaction.
+func getTaskMetadataInTransaction(tx *firestore.Transaction, ref *firestore.DocumentRef) (*tasks.TaskMetadata, error) {
+	dsnap, err := tx.Get(ref)
 	if err != nil {
-		return fmt.Errorf("app.Firestore -> %w", err)
+		return nil, fmt.Errorf("tx.Get -> %w", err)
 	}
-	defer client.Close()
-
-	data := structs.Map(t)
-	delete(data, "Region")
-	delete(data, "Sequence")
 
-	_, err = client.Collection(firestoreCollectionName).Doc(taskID).Collection(t.Region).Doc(strconv.Itoa(t.Sequence)).Set(ctx, data)
+	metadata, err := tasks.NewTaskMetadata()
 	if err != nil {
-		return fmt.Errorf("client.Collection.Set -> %w", err)
+		return nil, fmt.Errorf("tasks.NewTaskMetadata -> %w", err)
 	}
 
-	return nil
+	if err := dsnap.DataTo(metadata); err != nil {
+		return nil, fmt.Errorf("dsnap.DataTo -> %w", err)
+	}
+	return metadata, nil
 }
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/rafikurnia/measurement-measurer/tasks"
+)
+
+// setupEmulatorTask creates a running task in the Firestore emulator, or
+// skips the test when the emulator is not available.
+func setupEmulatorTask(t *testing.T) (*firestore.Client, string, string) {
+	if os.Getenv("FIRESTORE_EMULATOR_HOST") == "" {
+		t.Skip("FIRESTORE_EMULATOR_HOST is not set")
+	}
+
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, "measurement-test")
+	if !assert.Nil(t, err, "The Firestore client must be created.") {
+		return nil, "", ""
+	}
+
+	firestoreCollectionName = "tasks-test"
+	taskID := fmt.Sprintf("task-%d", time.Now().UnixNano())
+	region := "test-region"
+
+	_, err = client.Collection(firestoreCollectionName).Doc(taskID).Set(ctx, map[string]interface{}{
+		"Status":           "running",
+		"Schedule":         map[string]interface{}{"StopTime": time.Now().Add(time.Hour)},
+		"NumberOfSequence": map[string]interface{}{region: 0, "other-region": 0},
+	})
+	if !assert.Nil(t, err, "The task must be created.") {
+		client.Close()
+		return nil, "", ""
+	}
+	return client, taskID, region
+}
+
+// The tests below run against the Firestore emulator, e.g.
+// after `gcloud emulators firestore start --host-port=localhost:8081` and
+// `export FIRESTORE_EMULATOR_HOST=localhost:8081`.
+func TestCommitMeasurementConflicts(t *testing.T) {
+	client, taskID, region := setupEmulatorTask(t)
+	if client == nil {
+		return
+	}
+	defer client.Close()
+	ctx := context.Background()
+
+	const invocations = 10
+	sequences := make([]int, 0, invocations)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < invocations; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			task := &tasks.Task{Region: region, Measurement: tasks.Measurement{Outcome: tasks.OutcomeSuccess}}
+			_, err := commitMeasurement(ctx, client, taskID, task, "")
+			assert.Nil(t, err, "Concurrent commits must all succeed.")
+
+			mu.Lock()
+			sequences = append(sequences, task.Sequence)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	sort.Ints(sequences)
+	expected := make([]int, invocations)
+	for i := range expected {
+		expected[i] = i + 1
+	}
+	assert.Equal(t, expected, sequences, "Every commit must be given its own sequence number.")
+
+	metadata, err := getTaskMetadata(ctx, client, taskID)
+	if assert.Nil(t, err, "The task must be readable.") {
+		assert.Equal(t, invocations, metadata.NumberOfSequence[region], "The counter must match the number of commits.")
+		assert.Equal(t, "running", metadata.Status, "The task must not finish before its stop time.")
+	}
+
+	docs, err := client.Collection(firestoreCollectionName).Doc(taskID).Collection(region).Documents(ctx).GetAll()
+	if assert.Nil(t, err, "The results must be readable.") {
+		assert.Equal(t, invocations, len(docs), "No result may be overwritten.")
+	}
+}
+
+func TestCommitMeasurementDuplicateExecution(t *testing.T) {
+	client, taskID, region := setupEmulatorTask(t)
+	if client == nil {
+		return
+	}
+	defer client.Close()
+	ctx := context.Background()
+
+	key := "schedule:2022-10-18T10:00:00Z"
+	first := &tasks.Task{Region: region, ExecutionKey: key}
+	_, err := commitMeasurement(ctx, client, taskID, first, key)
+	assert.Nil(t, err, "The first execution must be committed.")
+
+	second := &tasks.Task{Region: region, ExecutionKey: key}
+	_, err = commitMeasurement(ctx, client, taskID, second, key)
+	assert.ErrorIs(t, err, errDuplicateExecution, "A second execution with the same key must be rejected.")
+
+	existing, err := findExecution(ctx, client, taskID, region, key)
+	if assert.Nil(t, err, "The existing execution must be found.") {
+		assert.Equal(t, int64(1), existing["Sequence"], "The existing result must be returned.")
+	}
+}
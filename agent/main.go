@@ -10,14 +10,91 @@ import (
 	"syscall"
 	"time"
 
+	"cloud.google.com/go/firestore"
+
+	firebase "firebase.google.com/go"
+
 	"github.com/rafikurnia/measurement-measurer/api"
+	"github.com/rafikurnia/measurement-measurer/probes"
+	"github.com/rafikurnia/measurement-measurer/tasks"
+	"github.com/rafikurnia/measurement-measurer/utils/geoip"
 	"github.com/rafikurnia/measurement-measurer/utils/logger"
+	"github.com/rafikurnia/measurement-measurer/utils/tracing"
 )
 
 func main() {
+	// The agent runs again as the sandbox of every external command.
+	probes.RunSandbox()
+
 	log.SetFlags(0)
 
-	router, err := api.SetupRouter()
+	shutdownTracing, err := tracing.Setup(os.Getenv("REGION"))
+	if err != nil {
+		log.Println(logger.Entry{
+			Severity:  "WARN",
+			Message:   fmt.Errorf("tracing.Setup -> %w", err).Error(),
+			Component: "main",
+		})
+	}
+
+	policy, err := probes.TargetPolicyFromEnv()
+	if err != nil {
+		log.Println(logger.Entry{
+			Severity:  "CRITICAL",
+			Message:   fmt.Errorf("probes.TargetPolicyFromEnv -> %w", err).Error(),
+			Component: "main",
+		})
+		policy = probes.DefaultTargetPolicy()
+	}
+	probes.SetTargetPolicy(policy)
+
+	geoipDir := os.Getenv("GEOIP_DIR")
+	if geoipDir == "" {
+		geoipDir = "geoip"
+	}
+	db, err := geoip.Open(geoipDir)
+	if err != nil {
+		log.Println(logger.Entry{
+			Severity:  "WARN",
+			Message:   fmt.Errorf("geoip.Open -> %w", err).Error(),
+			Component: "main",
+		})
+	} else {
+		defer db.Close()
+	}
+
+	nodeCtx, nodeCancel := context.WithTimeout(context.Background(), 3*time.Second)
+	if err := tasks.InitNodeInfo(nodeCtx, db); err != nil {
+		log.Println(logger.Entry{
+			Severity:  "WARN",
+			Message:   fmt.Errorf("tasks.InitNodeInfo -> %w", err).Error(),
+			Component: "main",
+		})
+	}
+	nodeCancel()
+
+	client, err := newFirestoreClient(context.Background())
+	if err != nil {
+		log.Println(logger.Entry{
+			Severity:  "CRITICAL",
+			Message:   fmt.Errorf("newFirestoreClient -> %w", err).Error(),
+			Component: "main",
+		})
+	} else {
+		defer client.Close()
+	}
+
+	modules, err := api.LoadProbeModules(os.Getenv("PROBE_MODULES_FILE"))
+	if err != nil {
+		log.Println(logger.Entry{
+			Severity:  "CRITICAL",
+			Message:   fmt.Errorf("api.LoadProbeModules -> %w", err).Error(),
+			Component: "main",
+		})
+		modules = api.DefaultProbeModules()
+	}
+
+	router, err := api.SetupRouter(&api.Server{Firestore: client, GeoIP: db, Modules: modules})
 	if err != nil {
 		log.Println(logger.Entry{
 			Severity:  "CRITICAL",
@@ -73,9 +150,32 @@ func main() {
 			Component: "main",
 		})
 	}
+	if shutdownTracing != nil {
+		if err := shutdownTracing(ctx); err != nil {
+			log.Println(logger.Entry{
+				Severity:  "WARN",
+				Message:   fmt.Errorf("shutdownTracing -> %w", err).Error(),
+				Component: "main",
+			})
+		}
+	}
 	log.Println(logger.Entry{
 		Severity:  "INFO",
 		Message:   "Server Exited Properly",
 		Component: "main",
 	})
 }
+
+// newFirestoreClient sets up the Firestore client shared by every request.
+func newFirestoreClient(ctx context.Context) (*firestore.Client, error) {
+	app, err := firebase.NewApp(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("firebase.NewApp -> %w", err)
+	}
+
+	client, err := app.Firestore(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("app.Firestore -> %w", err)
+	}
+	return client, nil
+}
@@ -0,0 +1,112 @@
+package probes
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// splitArguments breaks the task arguments into fields the way a POSIX
+// shell would, honouring single quotes, double quotes and backslashes,
+// without expanding anything.
+func splitArguments(args string) ([]string, error) {
+	fields := make([]string, 0)
+
+	var current strings.Builder
+	inField := false
+	var quote rune
+	escaped := false
+
+	for _, r := range args {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else if r == '\\' && quote == '"' {
+				escaped = true
+			} else {
+				current.WriteRune(r)
+			}
+
+		case r == '\\':
+			escaped = true
+			inField = true
+
+		case r == '\'' || r == '"':
+			quote = r
+			inField = true
+
+		case unicode.IsSpace(r):
+			if inField {
+				fields = append(fields, current.String())
+				current.Reset()
+				inField = false
+			}
+
+		default:
+			current.WriteRune(r)
+			inField = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("Unterminated %c quote in the arguments.", quote)
+	}
+	if escaped {
+		return nil, errors.New("The arguments end with a dangling backslash.")
+	}
+	if inField {
+		fields = append(fields, current.String())
+	}
+	return fields, nil
+}
+
+// WithTarget appends target to args as a single argument, quoted so that
+// splitArguments gives it back unchanged.
+func WithTarget(args, target string) string {
+	quoted := "'" + strings.ReplaceAll(target, "'", `'\''`) + "'"
+	if strings.TrimSpace(args) == "" {
+		return quoted
+	}
+	return args + " " + quoted
+}
+
+// newFlagSet returns a flag set that reports errors instead of exiting and
+// does not print anything, so that it can be used to parse task arguments.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	return fs
+}
+
+// parseFlags splits args, parses them with fs and returns the remaining
+// positional arguments.
+func parseFlags(fs *flag.FlagSet, args string) ([]string, error) {
+	fields, err := splitArguments(args)
+	if err != nil {
+		return nil, err
+	}
+	if err := fs.Parse(fields); err != nil {
+		return nil, fmt.Errorf("fs.Parse -> %w", err)
+	}
+	return fs.Args(), nil
+}
+
+// seconds converts a number of seconds, as accepted by ping and
+// traceroute, into a time.Duration.
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// milliseconds converts a duration into fractional milliseconds.
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
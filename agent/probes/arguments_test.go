@@ -0,0 +1,233 @@
+package probes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitArguments(t *testing.T) {
+	fields, err := splitArguments(`-c 3  "quoted host" 'single\quote' escaped\ space`)
+
+	assert.Nil(t, err, "Well-formed arguments must be split without error.")
+	assert.Equal(t, []string{"-c", "3", "quoted host", `single\quote`, "escaped space"}, fields, "The fields must be split like a shell would.")
+}
+
+func TestSplitArgumentsUnterminatedQuote(t *testing.T) {
+	_, err := splitArguments(`google.com "unterminated`)
+
+	assert.NotNil(t, err, "An unterminated quote must be rejected.")
+}
+
+func TestParsePingArguments(t *testing.T) {
+	opts, err := parsePingArguments("-c 5 -i 0.2 -s 100 -t 32 -W 2 -4 google.com")
+
+	assert.Nil(t, err, "Valid ping arguments must be accepted.")
+	assert.Equal(t, 5, opts.count, "The count must be parsed.")
+	assert.Equal(t, 200*time.Millisecond, opts.interval, "The interval must be parsed in seconds.")
+	assert.Equal(t, 100, opts.size, "The packet size must be parsed.")
+	assert.Equal(t, 32, opts.ttl, "The TTL must be parsed.")
+	assert.Equal(t, 2*time.Second, opts.timeout, "The timeout must be parsed in seconds.")
+	assert.Equal(t, "ip4", opts.network, "The -4 switch must select IPv4.")
+	assert.Equal(t, "google.com", opts.host, "The host must be parsed.")
+}
+
+func TestParsePingArgumentsDefaults(t *testing.T) {
+	opts, err := parsePingArguments("google.com")
+
+	assert.Nil(t, err, "A bare host must be accepted.")
+	assert.Equal(t, 1, opts.count, "A single echo request must be sent by default.")
+	assert.Equal(t, "ip", opts.network, "Both address families must be allowed by default.")
+}
+
+func TestParsePingArgumentsInvalid(t *testing.T) {
+	for _, args := range []string{"", "-c 0 google.com", "-c 3", "google.com example.com", "-4 -6 google.com"} {
+		_, err := parsePingArguments(args)
+		assert.NotNil(t, err, "Invalid ping arguments must be rejected: %q", args)
+	}
+}
+
+func TestParseTracerouteArguments(t *testing.T) {
+	opts, err := parseTracerouteArguments("-M tcp -m 20 -q 1 google.com")
+
+	assert.Nil(t, err, "Valid traceroute arguments must be accepted.")
+	assert.Equal(t, "tcp", opts.protocol, "The probe method must be parsed.")
+	assert.Equal(t, 80, opts.port, "TCP probes must target port 80 by default.")
+	assert.Equal(t, 20, opts.maxTTL, "The maximum TTL must be parsed.")
+	assert.Equal(t, 1, opts.queries, "The number of probes per hop must be parsed.")
+}
+
+func TestParseTracerouteArgumentsInvalid(t *testing.T) {
+	for _, args := range []string{"", "-M sctp google.com", "-m 0 google.com", "-f 10 -m 5 google.com", "-q 20 google.com"} {
+		_, err := parseTracerouteArguments(args)
+		assert.NotNil(t, err, "Invalid traceroute arguments must be rejected: %q", args)
+	}
+}
+
+func TestParseCurlArguments(t *testing.T) {
+	argv, err := curlSchema.parse(`-H "Accept: */*" --max-time=5 https://example.com/$(id)`)
+
+	assert.Nil(t, err, "Allowed curl options must be accepted.")
+	assert.Equal(t, []string{"-H", "Accept: */*", "--max-time", "5", "--", "https://example.com/$(id)"}, argv, "The arguments must be passed as-is, without a shell.")
+}
+
+func TestParseCurlArgumentsInvalid(t *testing.T) {
+	for _, args := range []string{
+		"-o /tmp/out https://example.com",
+		"-d @/etc/passwd https://example.com",
+		"-H @/proc/self/environ https://example.com",
+		"--header=@/etc/passwd https://example.com",
+		"--config=/etc/curlrc https://example.com",
+		"file:///etc/passwd",
+		"https://example.com https://example.org",
+		"https://example.com; id",
+	} {
+		_, err := curlSchema.parse(args)
+		assert.NotNil(t, err, "Invalid curl arguments must be rejected: %s", args)
+	}
+}
+
+func TestWithTarget(t *testing.T) {
+	fields, err := splitArguments(WithTarget("-c 3", "it's a host"))
+
+	assert.Nil(t, err, "Arguments with a target must be split without error.")
+	assert.Equal(t, []string{"-c", "3", "it's a host"}, fields, "The target must be a single argument.")
+}
+
+func TestParseDNSArguments(t *testing.T) {
+	opts, err := parseDNSArguments("-t aaaa -T dot -s 1.1.1.1 -W 2 example.com")
+
+	assert.Nil(t, err, "Valid dns arguments must be accepted.")
+	assert.Equal(t, "AAAA", opts.recordType, "The record type must be parsed regardless of case.")
+	assert.Equal(t, "dot", opts.transport, "The transport must be parsed.")
+	assert.Equal(t, "1.1.1.1:853", opts.resolver, "DoT resolvers must default to port 853.")
+	assert.Equal(t, 2*time.Second, opts.timeout, "The timeout must be parsed in seconds.")
+	assert.Equal(t, "example.com.", opts.name, "The query name must be fully qualified.")
+}
+
+func TestParseDNSArgumentsDefaults(t *testing.T) {
+	opts, err := parseDNSArguments("example.com")
+
+	assert.Nil(t, err, "A bare name must be accepted.")
+	assert.Equal(t, "A", opts.recordType, "A records must be queried by default.")
+	assert.Equal(t, "udp", opts.transport, "UDP must be used by default.")
+	assert.Equal(t, "", opts.resolver, "The system resolver must be used by default.")
+}
+
+func TestParseDNSArgumentsInvalid(t *testing.T) {
+	for _, args := range []string{
+		"",
+		"-t PTR example.com",
+		"-T foo example.com",
+		"-T doh -s http://dns.google/dns-query example.com",
+		"-W 0 example.com",
+		"example.com example.org",
+		"-t A",
+	} {
+		_, err := parseDNSArguments(args)
+		assert.NotNil(t, err, "Invalid dns arguments must be rejected: %q", args)
+	}
+}
+
+func TestParseTLSArguments(t *testing.T) {
+	opts, err := parseTLSArguments("-sni www.example.com -alpn 'h2, http/1.1' -expiry 14 -W 5 -6 example.com:8443")
+
+	assert.Nil(t, err, "Valid tls arguments must be accepted.")
+	assert.Equal(t, "www.example.com", opts.serverName, "The server name must be parsed.")
+	assert.Equal(t, []string{"h2", "http/1.1"}, opts.alpn, "The ALPN protocols must be split on commas.")
+	assert.Equal(t, 14, opts.expiryWindow, "The expiry window must be parsed in days.")
+	assert.Equal(t, 5*time.Second, opts.timeout, "The timeout must be parsed in seconds.")
+	assert.Equal(t, "tcp6", opts.network, "The -6 switch must select IPv6.")
+	assert.Equal(t, "example.com:8443", opts.address, "The port must be kept.")
+}
+
+func TestParseTLSArgumentsDefaults(t *testing.T) {
+	opts, err := parseTLSArguments("example.com")
+	assert.Nil(t, err, "A bare host must be accepted.")
+	assert.Equal(t, "example.com:443", opts.address, "The port must default to 443.")
+	assert.Equal(t, "example.com", opts.serverName, "The server name must default to the host.")
+
+	opts, err = parseTLSArguments("[2001:db8::1]")
+	assert.Nil(t, err, "A bracketed IPv6 address must be accepted.")
+	assert.Equal(t, "[2001:db8::1]:443", opts.address, "The port must be added to an IPv6 address.")
+	assert.Equal(t, "", opts.serverName, "No server name must be sent to an IP address.")
+}
+
+func TestParseTLSArgumentsInvalid(t *testing.T) {
+	for _, args := range []string{"", "-expiry -1 example.com", "-W 120 example.com", ":443", "-4 -6 example.com", "example.com example.org"} {
+		_, err := parseTLSArguments(args)
+		assert.NotNil(t, err, "Invalid tls arguments must be rejected: %q", args)
+	}
+}
+
+func TestParseTCPArguments(t *testing.T) {
+	opts, err := parseTCPArguments("-c 3 -i 0.5 -W 1 -4 example.com:443")
+
+	assert.Nil(t, err, "Valid tcp arguments must be accepted.")
+	assert.Equal(t, 3, opts.count, "The count must be parsed.")
+	assert.Equal(t, 500*time.Millisecond, opts.interval, "The interval must be parsed in seconds.")
+	assert.Equal(t, time.Second, opts.timeout, "The timeout must be parsed in seconds.")
+	assert.Equal(t, "ip4", opts.network, "The -4 switch must select IPv4.")
+	assert.Equal(t, "example.com", opts.host, "The host must be parsed.")
+	assert.Equal(t, "443", opts.port, "The port must be parsed.")
+}
+
+func TestParseTCPArgumentsInvalid(t *testing.T) {
+	for _, args := range []string{
+		"",
+		"example.com",
+		"example.com:",
+		":443",
+		"example.com:99999",
+		"-c 0 example.com:443",
+		"-i 20 example.com:443",
+		"-W 0 example.com:443",
+		"example.com:443 example.org:443",
+	} {
+		_, err := parseTCPArguments(args)
+		assert.NotNil(t, err, "Invalid tcp arguments must be rejected: %q", args)
+	}
+}
+
+func TestParseHTTPArguments(t *testing.T) {
+	opts, err := parseHTTPArguments(`-H 'Accept: text/html' -H "X-Test:  1" -d '{}' -max-redirs 2 -http2 -k -m 5 -max-body 1024 -capture server,etag https://example.com/`)
+
+	assert.Nil(t, err, "Valid httpstat arguments must be accepted.")
+	assert.Equal(t, "POST", opts.method, "A request with a body must default to POST.")
+	assert.Equal(t, "text/html", opts.headers.Get("Accept"), "The headers must be parsed.")
+	assert.Equal(t, "1", opts.headers.Get("X-Test"), "The header values must be trimmed.")
+	assert.Equal(t, 2, opts.maxRedirects, "The maximum number of redirects must be parsed.")
+	assert.True(t, opts.http2 && opts.insecure, "The switches must be parsed.")
+	assert.Equal(t, 5*time.Second, opts.timeout, "The timeout must be parsed in seconds.")
+	assert.Equal(t, int64(1024), opts.maxBody, "The maximum body size must be parsed.")
+	assert.Equal(t, []string{"Server", "Etag"}, opts.capture, "The captured headers must be canonicalised.")
+}
+
+func TestParseHTTPArgumentsDefaults(t *testing.T) {
+	opts, err := parseHTTPArguments("http://example.com")
+
+	assert.Nil(t, err, "A bare URL must be accepted.")
+	assert.Equal(t, "GET", opts.method, "GET must be used by default.")
+	assert.Equal(t, 10, opts.maxRedirects, "Up to 10 redirects must be followed by default.")
+	assert.Equal(t, defaultCapturedHeaders, opts.capture, "The default headers must be captured.")
+}
+
+func TestParseHTTPArgumentsInvalid(t *testing.T) {
+	for _, args := range []string{
+		"",
+		"example.com",
+		"ftp://example.com",
+		"-X TRACE https://example.com",
+		"-H NoColon https://example.com",
+		"-http1.1 -http2 https://example.com",
+		"-http2 http://example.com",
+		"-max-redirs 31 https://example.com",
+		"-max-body -1 https://example.com",
+		"-m 0 https://example.com",
+		"https://example.com https://example.org",
+	} {
+		_, err := parseHTTPArguments(args)
+		assert.NotNil(t, err, "Invalid httpstat arguments must be rejected: %q", args)
+	}
+}
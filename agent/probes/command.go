@@ -0,0 +1,270 @@
+package probes
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// Limits of the external commands, which run in a sandbox of their own.
+const (
+	// maxCommandOutput is the number of bytes of output that are kept.
+	maxCommandOutput = 1 << 20
+	// maxCommandCPUTime is the CPU time a command may use, in seconds.
+	maxCommandCPUTime = 30
+	// maxCommandMemory is the size of the address space of a command, in
+	// bytes.
+	maxCommandMemory = 512 << 20
+)
+
+// ErrMissingBinary is returned, wrapped, by the check of a probe whose
+// external command is not installed on the instance.
+var ErrMissingBinary = errors.New("The command of the probe is not installed")
+
+// CommandResult holds the combined stdout and stderr of an external command.
+// When the output exceeds maxCommandOutput, only its beginning is kept and
+// Truncated is set.
+type CommandResult struct {
+	Output      string `json:"output"`
+	Truncated   bool   `json:"truncated,omitempty"`
+	OutputBytes int64  `json:"output_bytes,omitempty"`
+}
+
+// markTruncated records that the output was cut, and its full size.
+func (r *CommandResult) markTruncated(total int64) {
+	r.Truncated = true
+	r.OutputBytes = total
+}
+
+func (r *CommandResult) String() string {
+	return r.Output
+}
+
+// commandFlag describes an option that a task may pass to an external
+// command.
+type commandFlag struct {
+	// takesValue is set when the option consumes the next argument.
+	takesValue bool
+	// validate, if set, checks the value of the option.
+	validate func(value string) error
+}
+
+// commandSchema is the allowlist of options and the validation of the
+// positional arguments accepted by an external command.
+type commandSchema struct {
+	flags      map[string]commandFlag
+	positional func(args []string) error
+}
+
+// parse checks every argument against the schema and returns them as an
+// argv list. Options may be given as "-f value", "--flag value" or
+// "--flag=value"; combined short options are not supported.
+func (s *commandSchema) parse(args string) ([]string, error) {
+	fields, err := splitArguments(args)
+	if err != nil {
+		return nil, err
+	}
+
+	argv := make([]string, 0, len(fields))
+	positional := make([]string, 0)
+	for i := 0; i < len(fields); i++ {
+		field := fields[i]
+		if field == "--" {
+			positional = append(positional, fields[i+1:]...)
+			break
+		}
+		if !strings.HasPrefix(field, "-") || field == "-" {
+			positional = append(positional, field)
+			continue
+		}
+
+		name, value, hasValue := field, "", false
+		if strings.HasPrefix(field, "--") {
+			if j := strings.Index(field, "="); j >= 0 {
+				name, value, hasValue = field[:j], field[j+1:], true
+			}
+		}
+
+		flag, ok := s.flags[name]
+		if !ok {
+			return nil, fmt.Errorf("The option is not allowed: '%s'", name)
+		}
+
+		if !flag.takesValue {
+			if hasValue {
+				return nil, fmt.Errorf("The option does not take a value: '%s'", name)
+			}
+			argv = append(argv, name)
+			continue
+		}
+
+		if !hasValue {
+			if i+1 >= len(fields) {
+				return nil, fmt.Errorf("The option requires a value: '%s'", name)
+			}
+			i++
+			value = fields[i]
+		}
+		if flag.validate != nil {
+			if err := flag.validate(value); err != nil {
+				return nil, fmt.Errorf("Invalid value for '%s': %w", name, err)
+			}
+		}
+		argv = append(argv, name, value)
+	}
+
+	if s.positional != nil {
+		if err := s.positional(positional); err != nil {
+			return nil, err
+		}
+	}
+
+	// Positional arguments go after a "--" so that they can never be
+	// interpreted as options.
+	argv = append(argv, "--")
+	return append(argv, positional...), nil
+}
+
+// commandProbe runs an external command, without a shell, with the task
+// arguments checked against a schema, and optionally parses its output into
+// a typed result.
+type commandProbe struct {
+	binary string
+	// fixed are options always passed before the task arguments.
+	fixed  []string
+	schema *commandSchema
+	// prepare, if set, checks and completes the argv list before the
+	// command runs.
+	prepare func(ctx context.Context, argv []string) ([]string, error)
+	parse   func(output string) (Result, error)
+}
+
+func (p *commandProbe) Validate(args string) error {
+	if strings.TrimSpace(args) == "" {
+		return errors.New("The arguments for the measurement probe cannot be empty.")
+	}
+	_, err := p.schema.parse(args)
+	return err
+}
+
+func (p *commandProbe) Check() error {
+	if _, err := exec.LookPath(p.binary); err != nil {
+		return fmt.Errorf("%w: '%s'", ErrMissingBinary, p.binary)
+	}
+	return nil
+}
+
+func (p *commandProbe) Run(ctx context.Context, args string) (Result, error) {
+	argv, err := p.schema.parse(args)
+	if err != nil {
+		return nil, err
+	}
+	return p.run(ctx, argv)
+}
+
+// run executes the command with an argv list returned by the schema.
+func (p *commandProbe) run(ctx context.Context, argv []string) (Result, error) {
+	var err error
+	if p.prepare != nil {
+		if argv, err = p.prepare(ctx, argv); err != nil {
+			return nil, err
+		}
+	}
+
+	argv = append(append(make([]string, 0, len(p.fixed)+len(argv)), p.fixed...), argv...)
+	cmd, err := sandboxCommand(p.binary, argv)
+	if err != nil {
+		return nil, fmt.Errorf("sandboxCommand -> %w", err)
+	}
+
+	// Get the pipe for stdout
+	cmdReader, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("cmd.StdoutPipe -> %w", err)
+	}
+
+	// Set stderr to also being sent to stdout
+	cmd.Stderr = cmd.Stdout
+
+	// Start executing the command
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("cmd.Start -> %w", err)
+	}
+
+	// The process group is killed once the command has exited, so that
+	// nothing it forked outlives it or keeps its output open, and at the
+	// deadline. Both happen before the command is reaped, after which its
+	// process group could be reused.
+	exited := killGroupOnExit(cmd)
+	stop := make(chan struct{})
+	watched := make(chan struct{})
+	go func() {
+		defer close(watched)
+		select {
+		case <-ctx.Done():
+			killProcessGroup(cmd)
+		case <-stop:
+		}
+	}()
+
+	output := &cappedBuffer{max: maxCommandOutput}
+	_, copyErr := io.Copy(output, cmdReader)
+	if copyErr != nil {
+		killProcessGroup(cmd)
+	}
+	<-exited
+	close(stop)
+	<-watched
+
+	err = cmd.Wait()
+	if copyErr != nil && ctx.Err() == nil {
+		return nil, fmt.Errorf("io.Copy -> %w", copyErr)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("cmd.Wait -> %w", err)
+	}
+
+	// Truncated output is kept as it is, since its end, which the parser
+	// usually reads, is missing.
+	if output.truncated {
+		result := &CommandResult{Output: output.String()}
+		result.markTruncated(output.total)
+		return result, nil
+	}
+	if p.parse == nil {
+		return &CommandResult{Output: output.String()}, nil
+	}
+	return p.parse(output.String())
+}
+
+// cappedBuffer keeps the first max bytes written to it and counts the rest,
+// so that the command is never blocked on a full pipe.
+type cappedBuffer struct {
+	buf       bytes.Buffer
+	max       int
+	total     int64
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	b.total += int64(len(p))
+	if room := b.max - b.buf.Len(); room < len(p) {
+		b.truncated = true
+		if room > 0 {
+			b.buf.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+func (b *cappedBuffer) String() string {
+	return b.buf.String()
+}
@@ -0,0 +1,102 @@
+package probes
+
+import (
+	"context"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMain lets the test binary run as the sandbox of the commands, like
+// the agent does.
+func TestMain(m *testing.M) {
+	RunSandbox()
+	os.Exit(m.Run())
+}
+
+func TestCommandOutputTruncated(t *testing.T) {
+	p := &commandProbe{
+		binary: "sh",
+		fixed:  []string{"-c", "head -c 2000000 /dev/zero | tr '\\0' x; echo '{\"time_total\": 1}'"},
+		schema: &commandSchema{flags: map[string]commandFlag{}},
+		parse:  parseCurlOutput,
+	}
+
+	result, err := p.Run(context.Background(), "")
+
+	assert.Nil(t, err, "The truncated output must not be parsed.")
+	r := result.(*CommandResult)
+	assert.Equal(t, maxCommandOutput, len(r.Output), "The output must be cut at the limit.")
+	assert.True(t, r.Truncated, "The truncation must be recorded.")
+	assert.Equal(t, int64(2000018), r.OutputBytes, "The full size of the output must be recorded.")
+}
+
+func TestCommandProcessGroupKilled(t *testing.T) {
+	p := &commandProbe{
+		binary: "sh",
+		fixed:  []string{"-c", "sleep 30 & sleep 30"},
+		schema: &commandSchema{flags: map[string]commandFlag{}},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := p.Run(ctx, "")
+
+	assert.NotNil(t, err, "The command must be stopped at the deadline.")
+	assert.True(t, strings.Contains(err.Error(), "deadline"), "The deadline must be reported.")
+	assert.Less(t, time.Since(start), 5*time.Second, "The forked process must not keep the probe waiting.")
+}
+
+func TestCommandResourceLimits(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Commands are only sandboxed on Linux.")
+	}
+	p := &commandProbe{
+		binary: "sh",
+		fixed:  []string{"-c", "sh -c 'ulimit -t; ulimit -v'"},
+		schema: &commandSchema{flags: map[string]commandFlag{}},
+	}
+
+	result, err := p.Run(context.Background(), "")
+
+	if assert.Nil(t, err, "The command must succeed.") {
+		assert.Equal(t, "30\n524288\n", result.String(), "The limits must be set before the command starts and be inherited.")
+	}
+}
+
+func TestCommandForkedProcessKilled(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Process groups are only killed on Linux.")
+	}
+	p := &commandProbe{
+		binary: "sh",
+		fixed:  []string{"-c", "sleep 30 & echo $!"},
+		schema: &commandSchema{flags: map[string]commandFlag{}},
+	}
+
+	start := time.Now()
+	result, err := p.Run(context.Background(), "")
+
+	assert.Less(t, time.Since(start), 5*time.Second, "The forked process must not keep the output open.")
+	if assert.Nil(t, err, "The command must succeed.") {
+		killed := func() bool {
+			stat, err := os.ReadFile("/proc/" + strings.TrimSpace(result.String()) + "/stat")
+			return err != nil || strings.Fields(string(stat))[2] == "Z"
+		}
+		assert.Eventually(t, killed, time.Second, 10*time.Millisecond, "The forked process must be killed once the command has exited.")
+	}
+}
+
+func TestCommandMissingBinary(t *testing.T) {
+	p := &commandProbe{binary: "measurer-missing-binary", schema: &commandSchema{flags: map[string]commandFlag{}}}
+
+	_, err := p.Run(context.Background(), "")
+
+	assert.NotNil(t, err, "A missing command must be reported.")
+}
@@ -0,0 +1,326 @@
+package probes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// maxCurlRedirects is the number of redirects followed with -L, the default
+// of curl, and the most a task may ask for with --max-redirs.
+const maxCurlRedirects = 50
+
+// CurlResult holds the timings written by curlt, in seconds, along with
+// the raw output of the command. When redirects are followed, the timings
+// are those of curl -L: the phases are timed from the start of the last
+// request, and time_redirect covers every request before it.
+type CurlResult struct {
+	CommandResult
+	TimeNamelookup    float64 `json:"time_namelookup"`
+	TimeConnect       float64 `json:"time_connect"`
+	TimeAppconnect    float64 `json:"time_appconnect"`
+	TimePretransfer   float64 `json:"time_pretransfer"`
+	TimeRedirect      float64 `json:"time_redirect"`
+	TimeStarttransfer float64 `json:"time_starttransfer"`
+	TimeTotal         float64 `json:"time_total"`
+	HTTPCode          int     `json:"http_code"`
+	NumRedirects      int     `json:"num_redirects"`
+	// RedirectURL is the location of a redirect that was not followed.
+	RedirectURL string `json:"redirect_url,omitempty"`
+}
+
+// parseCurlOutput extracts the JSON line printed by curlt, and the
+// location of the redirect printed on the line after it, if any.
+func parseCurlOutput(output string) (Result, error) {
+	result := &CurlResult{CommandResult: CommandResult{Output: output}}
+
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	last, location := strings.TrimSpace(lines[len(lines)-1]), ""
+	if !strings.HasPrefix(last, "{") && len(lines) > 1 {
+		last, location = strings.TrimSpace(lines[len(lines)-2]), last
+	}
+	if !strings.HasPrefix(last, "{") {
+		return result, nil
+	}
+	if err := json.Unmarshal([]byte(last), result); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal -> %w", err)
+	}
+	result.RedirectURL = location
+	return result, nil
+}
+
+// Latency is the total time of the transfer, in milliseconds.
+func (r *CurlResult) Latency() (float64, bool) {
+	if r.TimeTotal == 0 {
+		return 0, false
+	}
+	return r.TimeTotal * 1000, true
+}
+
+// validateSeconds accepts a positive number of seconds no greater than max.
+func validateSeconds(max float64) func(string) error {
+	return func(value string) error {
+		s, err := strconv.ParseFloat(value, 64)
+		if err != nil || s <= 0 || s > max {
+			return fmt.Errorf("must be a number of seconds between 0 and %g", max)
+		}
+		return nil
+	}
+}
+
+// validateCurlData rejects request bodies that curl would read from a file.
+func validateCurlData(value string) error {
+	if strings.HasPrefix(value, "@") {
+		return errors.New("reading the body from a file is not allowed")
+	}
+	return nil
+}
+
+// validateCurlHeader rejects headers that curl would read from a file.
+func validateCurlHeader(value string) error {
+	if strings.HasPrefix(value, "@") {
+		return errors.New("reading the headers from a file is not allowed")
+	}
+	return nil
+}
+
+// validateCurlRedirects accepts the values of --max-redirs, where -1 means
+// no limit. More than maxCurlRedirects are never followed.
+func validateCurlRedirects(value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < -1 {
+		return errors.New("must be a number of redirects, or -1")
+	}
+	return nil
+}
+
+// validateCurlURL requires exactly one http or https URL whose host is
+// allowed by the target policy.
+func validateCurlURL(args []string) error {
+	if len(args) != 1 {
+		return errors.New("The arguments must contain exactly one URL.")
+	}
+	u, err := url.Parse(args[0])
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("The arguments must contain URL starts with either 'http://' or 'https://'.")
+	}
+	return targetPolicy.CheckHost(u.Hostname())
+}
+
+// curlSchema only allows options that neither read nor write local files,
+// nor change where the output of curlt goes. -L and --location are never
+// passed to curlt, which would connect to the new location without the
+// target policy being checked: curlProbe follows the redirects instead.
+var curlSchema = &commandSchema{
+	flags: map[string]commandFlag{
+		"-4":                {},
+		"-6":                {},
+		"-I":                {},
+		"--head":            {},
+		"-L":                {},
+		"--location":        {},
+		"-k":                {},
+		"--insecure":        {},
+		"--compressed":      {},
+		"--http1.0":         {},
+		"--http1.1":         {},
+		"--http2":           {},
+		"--tlsv1.2":         {},
+		"--tlsv1.3":         {},
+		"-X":                {takesValue: true},
+		"--request":         {takesValue: true},
+		"-H":                {takesValue: true, validate: validateCurlHeader},
+		"--header":          {takesValue: true, validate: validateCurlHeader},
+		"-A":                {takesValue: true},
+		"--user-agent":      {takesValue: true},
+		"-e":                {takesValue: true},
+		"--referer":         {takesValue: true},
+		"-d":                {takesValue: true, validate: validateCurlData},
+		"--data":            {takesValue: true, validate: validateCurlData},
+		"--data-raw":        {takesValue: true},
+		"--max-redirs":      {takesValue: true, validate: validateCurlRedirects},
+		"-m":                {takesValue: true, validate: validateSeconds(120)},
+		"--max-time":        {takesValue: true, validate: validateSeconds(120)},
+		"--connect-timeout": {takesValue: true, validate: validateSeconds(60)},
+	},
+	positional: validateCurlURL,
+}
+
+// pinCurlTarget resolves the host of the URL, which is the last argument,
+// checks it against the target policy and pins curlt to the checked
+// address, so that curlt cannot resolve the host to another one. The name
+// lookup is therefore not part of the timings of curlt.
+func pinCurlTarget(ctx context.Context, argv []string) ([]string, error) {
+	u, err := url.Parse(argv[len(argv)-1])
+	if err != nil {
+		return nil, fmt.Errorf("url.Parse -> %w", err)
+	}
+
+	network := "ip"
+	for _, arg := range argv {
+		if arg == "--" {
+			break
+		}
+		switch arg {
+		case "-4":
+			network = "ip4"
+		case "-6":
+			network = "ip6"
+		}
+	}
+
+	host := u.Hostname()
+	ip, err := resolveIP(ctx, network, host)
+	if err != nil {
+		return nil, fmt.Errorf("resolveIP -> %w", err)
+	}
+	if net.ParseIP(host) != nil {
+		return argv, nil
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	address := ip.String()
+	if ip.To4() == nil {
+		address = "[" + address + "]"
+	}
+	return append([]string{"--resolve", fmt.Sprintf("%s:%s:%s", host, port, address)}, argv...), nil
+}
+
+// curlProbe runs curlt and, when the task passes -L, follows the redirects
+// itself, one run of curlt per location, so that every location is checked
+// against the target policy and pinned like the first one.
+type curlProbe struct {
+	commandProbe
+}
+
+func (p *curlProbe) Run(ctx context.Context, args string) (Result, error) {
+	argv, err := p.schema.parse(args)
+	if err != nil {
+		return nil, err
+	}
+	argv, follow, maxRedirects := curlRedirectOptions(argv)
+
+	var redirectTime float64
+	for redirects := 0; ; redirects++ {
+		result, err := p.run(ctx, argv)
+		if err != nil {
+			return nil, err
+		}
+		r, ok := result.(*CurlResult)
+		if !ok || !follow || r.RedirectURL == "" {
+			if ok {
+				r.NumRedirects = redirects
+				r.TimeRedirect = redirectTime
+				r.TimeTotal += redirectTime
+			}
+			return result, nil
+		}
+		if redirects == maxRedirects {
+			return nil, fmt.Errorf("Maximum (%d) redirects followed", maxRedirects)
+		}
+
+		if argv, err = redirectCurlArguments(argv, r); err != nil {
+			return nil, err
+		}
+		redirectTime += r.TimeTotal
+	}
+}
+
+// curlRedirectOptions removes -L and --location from argv, and returns
+// whether redirects must be followed and how many at most.
+func curlRedirectOptions(argv []string) ([]string, bool, int) {
+	kept := make([]string, 0, len(argv))
+	follow, maxRedirects := false, maxCurlRedirects
+	for i := 0; i < len(argv); i++ {
+		switch argv[i] {
+		case "--":
+			return append(kept, argv[i:]...), follow, maxRedirects
+		case "-L", "--location":
+			follow = true
+			continue
+		case "--max-redirs":
+			if n, err := strconv.Atoi(argv[i+1]); err == nil && n >= 0 && n < maxRedirects {
+				maxRedirects = n
+			}
+		}
+		kept = append(kept, argv[i])
+	}
+	return kept, follow, maxRedirects
+}
+
+// redirectCurlArguments returns the argv list requesting the location of a
+// redirect, changing the request the way curl -L does: the body is dropped
+// from a 301, 302 or 303 unless the method was set with -X, and the
+// Authorization and Cookie headers are not sent to another host.
+func redirectCurlArguments(argv []string, r *CurlResult) ([]string, error) {
+	location := []string{r.RedirectURL}
+	if err := validateCurlURL(location); err != nil {
+		return nil, err
+	}
+	from, err := url.Parse(argv[len(argv)-1])
+	if err != nil {
+		return nil, fmt.Errorf("url.Parse -> %w", err)
+	}
+	to, err := url.Parse(r.RedirectURL)
+	if err != nil {
+		return nil, fmt.Errorf("url.Parse -> %w", err)
+	}
+
+	options := argv[:len(argv)-2]
+	customMethod := false
+	for _, option := range options {
+		if option == "-X" || option == "--request" {
+			customMethod = true
+		}
+	}
+	dropBody := !customMethod && (r.HTTPCode == 301 || r.HTTPCode == 302 || r.HTTPCode == 303)
+
+	next := make([]string, 0, len(argv))
+	for i := 0; i < len(options); i++ {
+		name := options[i]
+		if !curlSchema.flags[name].takesValue {
+			next = append(next, name)
+			continue
+		}
+		value := options[i+1]
+		i++
+
+		switch name {
+		case "-d", "--data", "--data-raw":
+			if dropBody {
+				continue
+			}
+		case "-H", "--header":
+			header := strings.ToLower(strings.TrimSpace(strings.SplitN(value, ":", 2)[0]))
+			if to.Host != from.Host && (header == "authorization" || header == "cookie") {
+				continue
+			}
+		}
+		next = append(next, name, value)
+	}
+	return append(next, "--", r.RedirectURL), nil
+}
+
+func init() {
+	Register("curl", &curlProbe{commandProbe{
+		binary: "curlt",
+		// Never let a redirect take curl to file:// or another protocol,
+		// nor the URL be expanded by curl into other targets than the
+		// one checked.
+		fixed:   []string{"--globoff", "--proto", "=http,https", "--proto-redir", "=http,https"},
+		schema:  curlSchema,
+		prepare: pinCurlTarget,
+		parse:   parseCurlOutput,
+	}})
+}
@@ -0,0 +1,114 @@
+package probes
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCurlOutput(t *testing.T) {
+	timings := `{"time_namelookup": 0.001, "time_connect": 0.002, "time_appconnect": 0, "time_pretransfer": 0.003, "time_redirect": 0, "time_starttransfer": 0.004, "time_total": 0.005, "http_code": 302}`
+
+	result, err := parseCurlOutput(timings + "\nhttps://example.com/next\n")
+	if assert.Nil(t, err, "The output of curlt must be parsed.") {
+		r := result.(*CurlResult)
+		assert.Equal(t, 0.005, r.TimeTotal, "The timings must be parsed.")
+		assert.Equal(t, 302, r.HTTPCode, "The status code must be parsed.")
+		assert.Equal(t, "https://example.com/next", r.RedirectURL, "The location must be read from the line after the timings.")
+	}
+
+	result, err = parseCurlOutput(timings + "\n\n")
+	if assert.Nil(t, err, "The output of curlt must be parsed.") {
+		assert.Equal(t, "", result.(*CurlResult).RedirectURL, "An empty line must not be taken as a location.")
+	}
+
+	result, err = parseCurlOutput("curl: (6) Could not resolve host\n")
+	if assert.Nil(t, err, "An output without timings must be kept.") {
+		assert.Equal(t, 0.0, result.(*CurlResult).TimeTotal, "An output without timings must have none.")
+	}
+}
+
+func TestRedirectCurlArguments(t *testing.T) {
+	argv := []string{"-d", "a=1", "-H", "Authorization: Bearer x", "-H", "Accept: */*", "-k", "--", "https://example.com/login"}
+
+	next, err := redirectCurlArguments(argv, &CurlResult{HTTPCode: 302, RedirectURL: "https://example.com/home"})
+	assert.Nil(t, err, "A redirect to the same host must be followed.")
+	assert.Equal(t, []string{"-H", "Authorization: Bearer x", "-H", "Accept: */*", "-k", "--", "https://example.com/home"}, next, "The body must be dropped on a 302.")
+
+	next, err = redirectCurlArguments(argv, &CurlResult{HTTPCode: 307, RedirectURL: "https://www.example.com/login"})
+	assert.Nil(t, err, "A redirect to another host must be followed.")
+	assert.Equal(t, []string{"-d", "a=1", "-H", "Accept: */*", "-k", "--", "https://www.example.com/login"}, next, "The credentials must not be sent to another host.")
+
+	_, err = redirectCurlArguments(argv, &CurlResult{HTTPCode: 302, RedirectURL: "file:///etc/passwd"})
+	assert.NotNil(t, err, "A redirect to another protocol must be rejected.")
+}
+
+func TestCurlFollowsRedirects(t *testing.T) {
+	if _, err := os.Stat("../curlt"); err != nil {
+		t.Skip("curlt is not available")
+	}
+	if _, err := exec.LookPath("curl"); err != nil {
+		t.Skip("curl is not installed")
+	}
+	dir, _ := filepath.Abs("..")
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/a":
+			http.Redirect(w, r, "/b", http.StatusFound)
+		case "/b":
+			http.Redirect(w, r, "/c", http.StatusMovedPermanently)
+		case "/away":
+			http.Redirect(w, r, "http://localhost/", http.StatusFound)
+		default:
+			atomic.AddInt32(&requests, 1)
+			w.Write([]byte("ok"))
+		}
+	}))
+	defer server.Close()
+
+	probe, _ := Lookup("curl")
+
+	result, err := probe.Run(context.Background(), "-L "+server.URL+"/a")
+	if assert.Nil(t, err, "The redirects must be followed.") {
+		r := result.(*CurlResult)
+		assert.Equal(t, 200, r.HTTPCode, "The last response must be reported.")
+		assert.Equal(t, 2, r.NumRedirects, "Every redirect must be counted.")
+		assert.Greater(t, r.TimeRedirect, 0.0, "The time of the redirects must be reported.")
+		assert.GreaterOrEqual(t, r.TimeTotal, r.TimeRedirect, "The total time must include the redirects.")
+	}
+
+	result, err = probe.Run(context.Background(), server.URL+"/a")
+	if assert.Nil(t, err, "A redirect must not be followed without -L.") {
+		r := result.(*CurlResult)
+		assert.Equal(t, 302, r.HTTPCode, "The redirect must be reported.")
+		assert.Equal(t, server.URL+"/b", r.RedirectURL, "The location must be reported.")
+	}
+
+	atomic.StoreInt32(&requests, 0)
+	result, err = probe.Run(context.Background(), server.URL+"/{a,b}[1-2]")
+	if assert.Nil(t, err, "A URL with glob characters must be requested.") {
+		assert.Equal(t, 200, result.(*CurlResult).HTTPCode, "The URL must be requested as is.")
+		assert.Equal(t, int32(1), atomic.LoadInt32(&requests), "The URL must not be expanded into several.")
+	}
+
+	_, err = probe.Run(context.Background(), "-L --max-redirs 1 "+server.URL+"/a")
+	assert.NotNil(t, err, "No more than --max-redirs redirects must be followed.")
+
+	policy, _ := NewTargetPolicy(defaultDeniedCIDRs, []string{"127.0.0.1/32"}, defaultDeniedHosts, nil)
+	SetTargetPolicy(policy)
+	defer SetTargetPolicy(nil)
+
+	_, err = probe.Run(context.Background(), "-L "+server.URL+"/away")
+	assert.True(t, errors.Is(err, ErrTargetDenied), "A redirect to a denied target must not be followed.")
+}
@@ -0,0 +1,354 @@
+package probes
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// dnsRecordTypes lists the record types that the dns probe may query.
+var dnsRecordTypes = map[string]uint16{
+	"A":     dns.TypeA,
+	"AAAA":  dns.TypeAAAA,
+	"CNAME": dns.TypeCNAME,
+	"MX":    dns.TypeMX,
+	"TXT":   dns.TypeTXT,
+	"NS":    dns.TypeNS,
+	"SOA":   dns.TypeSOA,
+	"HTTPS": dns.TypeHTTPS,
+}
+
+// DNSAnswer is a single resource record of a DNS response.
+type DNSAnswer struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	TTL  uint32 `json:"ttl"`
+	Data string `json:"data"`
+}
+
+// DNSFlags holds the header flags of a DNS response.
+type DNSFlags struct {
+	Authoritative      bool `json:"aa"`
+	Truncated          bool `json:"tc"`
+	RecursionDesired   bool `json:"rd"`
+	RecursionAvailable bool `json:"ra"`
+	AuthenticatedData  bool `json:"ad"`
+	CheckingDisabled   bool `json:"cd"`
+}
+
+// DNSResult holds the outcome of a DNS query.
+type DNSResult struct {
+	Query        string      `json:"query"`
+	Type         string      `json:"type"`
+	Resolver     string      `json:"resolver"`
+	Transport    string      `json:"transport"`
+	RTT          float64     `json:"rtt_ms"`
+	Rcode        string      `json:"rcode"`
+	Flags        DNSFlags    `json:"flags"`
+	Answers      []DNSAnswer `json:"answers"`
+	ResponseSize int         `json:"response_size"`
+}
+
+func (r *DNSResult) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s @%s (%s): %s in %.3f ms, %d bytes\n", r.Query, r.Type, r.Resolver, r.Transport, r.Rcode, r.RTT, r.ResponseSize)
+	for _, a := range r.Answers {
+		fmt.Fprintf(&b, "%s\t%d\tIN\t%s\t%s\n", a.Name, a.TTL, a.Type, a.Data)
+	}
+	return b.String()
+}
+
+// Latency is the time to receive the response.
+func (r *DNSResult) Latency() (float64, bool) {
+	return r.RTT, true
+}
+
+type dnsOptions struct {
+	recordType string
+	resolver   string
+	transport  string
+	timeout    time.Duration
+	name       string
+}
+
+// parseDNSArguments accepts [-t type] [-s resolver] [-T udp|tcp|dot|doh]
+// [-W timeout] name. The resolver is a host[:port] for udp, tcp and dot and
+// a URL for doh; it defaults to the system resolver, or to Google Public DNS
+// for the encrypted transports.
+func parseDNSArguments(args string) (*dnsOptions, error) {
+	opts := &dnsOptions{}
+
+	var timeout float64
+	fs := newFlagSet("dns")
+	fs.StringVar(&opts.recordType, "t", "A", "record type")
+	fs.StringVar(&opts.resolver, "s", "", "resolver address")
+	fs.StringVar(&opts.transport, "T", "udp", "transport, one of udp, tcp, dot or doh")
+	fs.Float64Var(&timeout, "W", 5, "seconds to wait for the response")
+
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return nil, err
+	}
+	if len(positional) != 1 {
+		return nil, errors.New("The arguments must contain exactly one query name.")
+	}
+	opts.name = dns.Fqdn(positional[0])
+	if _, ok := dns.IsDomainName(opts.name); !ok {
+		return nil, fmt.Errorf("The query name is invalid: '%s'", positional[0])
+	}
+
+	opts.recordType = strings.ToUpper(opts.recordType)
+	if _, ok := dnsRecordTypes[opts.recordType]; !ok {
+		return nil, fmt.Errorf("The record type is not supported: '%s'", opts.recordType)
+	}
+
+	if timeout < 0.1 || timeout > 30 {
+		return nil, fmt.Errorf("The timeout must be between 0.1 and 30 seconds: %g", timeout)
+	}
+	opts.timeout = seconds(timeout)
+
+	opts.transport = strings.ToLower(opts.transport)
+	switch opts.transport {
+	case "udp", "tcp":
+		if opts.resolver != "" {
+			opts.resolver = withDefaultPort(opts.resolver, "53")
+		}
+	case "dot":
+		if opts.resolver == "" {
+			opts.resolver = "dns.google"
+		}
+		opts.resolver = withDefaultPort(opts.resolver, "853")
+	case "doh":
+		if opts.resolver == "" {
+			opts.resolver = "https://dns.google/dns-query"
+		}
+		u, err := url.Parse(opts.resolver)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return nil, fmt.Errorf("The DoH resolver must be an https URL: '%s'", opts.resolver)
+		}
+	default:
+		return nil, fmt.Errorf("The transport must be one of [udp|tcp|dot|doh]: '%s'", opts.transport)
+	}
+
+	return opts, nil
+}
+
+// withDefaultPort appends port to address unless it already has one.
+func withDefaultPort(address, port string) string {
+	if _, _, err := net.SplitHostPort(address); err == nil {
+		return address
+	}
+	return net.JoinHostPort(strings.Trim(address, "[]"), port)
+}
+
+// systemResolver returns the first nameserver of /etc/resolv.conf.
+func systemResolver() (string, error) {
+	cfg, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+	if err != nil {
+		return "", fmt.Errorf("dns.ClientConfigFromFile -> %w", err)
+	}
+	if len(cfg.Servers) == 0 {
+		return "", errors.New("No nameserver is configured in /etc/resolv.conf.")
+	}
+	return net.JoinHostPort(cfg.Servers[0], cfg.Port), nil
+}
+
+// exchangeDoH sends the query as an RFC 8484 POST request.
+func exchangeDoH(ctx context.Context, m *dns.Msg, endpoint string, dialer *net.Dialer) (*dns.Msg, time.Duration, int, error) {
+	packed, err := m.Pack()
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("m.Pack -> %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(packed))
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("http.NewRequest -> %w", err)
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext:       dialer.DialContext,
+		DisableKeepAlives: true,
+		ForceAttemptHTTP2: true,
+	}}
+	start := time.Now()
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("client.Do -> %w", err)
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	rtt := time.Since(start)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("ioutil.ReadAll -> %w", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, 0, 0, fmt.Errorf("The DoH resolver responded with status %d", res.StatusCode)
+	}
+
+	r := new(dns.Msg)
+	if err := r.Unpack(body); err != nil {
+		return nil, 0, 0, fmt.Errorf("r.Unpack -> %w", err)
+	}
+	return r, rtt, len(body), nil
+}
+
+// exchangeDNS sends the query over udp, tcp or dot like
+// dns.Client.ExchangeContext does, but also returns the size of the
+// response as received, before it is unpacked.
+func exchangeDNS(ctx context.Context, client *dns.Client, m *dns.Msg, address string) (*dns.Msg, time.Duration, int, error) {
+	conn, err := client.DialContext(ctx, address)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("client.DialContext -> %w", err)
+	}
+	defer conn.Close()
+	if opt := m.IsEdns0(); opt != nil {
+		conn.UDPSize = opt.UDPSize()
+	}
+
+	start := time.Now()
+	deadline := start.Add(client.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	if err := conn.WriteMsg(m); err != nil {
+		return nil, 0, 0, fmt.Errorf("conn.WriteMsg -> %w", err)
+	}
+	for {
+		raw, err := conn.ReadMsgHeader(nil)
+		if err != nil {
+			return nil, 0, 0, fmt.Errorf("conn.ReadMsgHeader -> %w", err)
+		}
+		rtt := time.Since(start)
+
+		r := new(dns.Msg)
+		if err := r.Unpack(raw); err != nil {
+			return nil, 0, 0, fmt.Errorf("r.Unpack -> %w", err)
+		}
+		if r.Id != m.Id {
+			// Over udp, a mismatched ID may answer an earlier query.
+			if client.Net == "udp" {
+				continue
+			}
+			return nil, 0, 0, dns.ErrId
+		}
+		return r, rtt, len(raw), nil
+	}
+}
+
+type dnsProbe struct{}
+
+func (p *dnsProbe) Validate(args string) error {
+	opts, err := parseDNSArguments(args)
+	if err != nil {
+		return err
+	}
+	// Only a resolver chosen by the task is checked; see Run.
+	switch {
+	case opts.resolver == "":
+		return nil
+	case opts.transport == "doh":
+		u, err := url.Parse(opts.resolver)
+		if err != nil {
+			return fmt.Errorf("url.Parse -> %w", err)
+		}
+		return targetPolicy.CheckHost(u.Hostname())
+	default:
+		host, _, err := net.SplitHostPort(opts.resolver)
+		if err != nil {
+			return fmt.Errorf("net.SplitHostPort -> %w", err)
+		}
+		return targetPolicy.CheckHost(host)
+	}
+}
+
+func (p *dnsProbe) Run(ctx context.Context, args string) (Result, error) {
+	opts, err := parseDNSArguments(args)
+	if err != nil {
+		return nil, err
+	}
+	// The system resolver is trusted, even though it is usually a private
+	// or link-local address, but a resolver chosen by the task is not.
+	dialer := &net.Dialer{Timeout: opts.timeout, Control: checkDialAddress}
+	if opts.resolver == "" {
+		dialer.Control = nil
+		if opts.resolver, err = systemResolver(); err != nil {
+			return nil, fmt.Errorf("systemResolver -> %w", err)
+		}
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion(opts.name, dnsRecordTypes[opts.recordType])
+	m.SetEdns0(dns.DefaultMsgSize, false)
+
+	var r *dns.Msg
+	var rtt time.Duration
+	var size int
+	if opts.transport == "doh" {
+		// RFC 8484 recommends a zero ID so that responses can be cached.
+		m.Id = 0
+		queryCtx, cancel := context.WithTimeout(ctx, opts.timeout)
+		defer cancel()
+		if r, rtt, size, err = exchangeDoH(queryCtx, m, opts.resolver, dialer); err != nil {
+			return nil, fmt.Errorf("exchangeDoH -> %w", err)
+		}
+	} else {
+		client := &dns.Client{Net: opts.transport, Timeout: opts.timeout, Dialer: dialer}
+		if opts.transport == "dot" {
+			host, _, _ := net.SplitHostPort(opts.resolver)
+			client.Net = "tcp-tls"
+			client.TLSConfig = &tls.Config{ServerName: host}
+		}
+		if r, rtt, size, err = exchangeDNS(ctx, client, m, opts.resolver); err != nil {
+			return nil, fmt.Errorf("exchangeDNS -> %w", err)
+		}
+	}
+
+	result := &DNSResult{
+		Query:     opts.name,
+		Type:      opts.recordType,
+		Resolver:  opts.resolver,
+		Transport: opts.transport,
+		RTT:       milliseconds(rtt),
+		Rcode:     dns.RcodeToString[r.Rcode],
+		Flags: DNSFlags{
+			Authoritative:      r.Authoritative,
+			Truncated:          r.Truncated,
+			RecursionDesired:   r.RecursionDesired,
+			RecursionAvailable: r.RecursionAvailable,
+			AuthenticatedData:  r.AuthenticatedData,
+			CheckingDisabled:   r.CheckingDisabled,
+		},
+		Answers:      make([]DNSAnswer, 0, len(r.Answer)),
+		ResponseSize: size,
+	}
+	for _, rr := range r.Answer {
+		h := rr.Header()
+		result.Answers = append(result.Answers, DNSAnswer{
+			Name: h.Name,
+			Type: dns.TypeToString[h.Rrtype],
+			TTL:  h.Ttl,
+			Data: strings.TrimPrefix(rr.String(), h.String()),
+		})
+	}
+
+	return result, nil
+}
+
+func init() {
+	Register("dns", &dnsProbe{})
+}
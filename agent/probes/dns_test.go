@@ -0,0 +1,54 @@
+package probes
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExchangeDNSResponseSize(t *testing.T) {
+	for _, network := range []string{"udp", "tcp"} {
+		var sent int
+		handler := dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+			r := new(dns.Msg)
+			r.SetReply(req)
+			for _, ip := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"} {
+				rr, _ := dns.NewRR("example.com. 60 IN A " + ip)
+				r.Answer = append(r.Answer, rr)
+			}
+			r.Compress = true
+			packed, _ := r.Pack()
+			sent = len(packed)
+			w.Write(packed)
+		})
+
+		server := &dns.Server{Net: network, Handler: handler}
+		var address string
+		if network == "udp" {
+			server.PacketConn, _ = net.ListenPacket("udp", "127.0.0.1:0")
+			address = server.PacketConn.LocalAddr().String()
+		} else {
+			server.Listener, _ = net.Listen("tcp", "127.0.0.1:0")
+			address = server.Listener.Addr().String()
+		}
+		started := make(chan struct{})
+		server.NotifyStartedFunc = func() { close(started) }
+		go server.ActivateAndServe()
+		<-started
+
+		m := new(dns.Msg)
+		m.SetQuestion("example.com.", dns.TypeA)
+		m.SetEdns0(dns.DefaultMsgSize, false)
+		client := &dns.Client{Net: network, Timeout: time.Second}
+		r, _, size, err := exchangeDNS(context.Background(), client, m, address)
+		server.Shutdown()
+
+		assert.Nil(t, err, "The query must be answered over %s.", network)
+		assert.Equal(t, sent, size, "The size must be that of the response received over %s.", network)
+		assert.Less(t, size, r.Len(), "The size must not be that of the response packed again over %s.", network)
+	}
+}
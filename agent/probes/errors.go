@@ -0,0 +1,41 @@
+package probes
+
+import (
+	"crypto/x509"
+	"errors"
+	"os/exec"
+)
+
+// ErrorClass maps the error returned by Probe.Run to a coarse class, so
+// that failed measurements can be grouped without parsing their messages.
+func ErrorClass(err error) string {
+	var exitErr *exec.ExitError
+	var execErr *exec.Error
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrTargetDenied):
+		return "denied"
+	case errors.As(err, &exitErr):
+		return "exit"
+	case errors.As(err, &execErr):
+		return "exec"
+	case errors.As(err, &unknownAuthority) || errors.As(err, &hostname) || errors.As(err, &invalid):
+		return "certificate"
+	default:
+		return classifyNetworkError(err)
+	}
+}
+
+// ExitCode returns the exit status of an external command that failed, or
+// -1 when err does not come from one.
+func ExitCode(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
@@ -0,0 +1,424 @@
+package probes
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultCapturedHeaders are the response headers recorded when the task
+// does not select any.
+var defaultCapturedHeaders = []string{
+	"Age",
+	"Cache-Control",
+	"Content-Length",
+	"Content-Type",
+	"Location",
+	"Server",
+	"Via",
+	"X-Cache",
+}
+
+// HTTPRedirect is one step of a redirect chain.
+type HTTPRedirect struct {
+	URL        string `json:"url"`
+	StatusCode int    `json:"status_code"`
+	Location   string `json:"location"`
+}
+
+// HTTPTimings holds the duration of each phase of the final request, in
+// milliseconds with microsecond precision.
+type HTTPTimings struct {
+	DNSLookup        float64 `json:"dns_lookup_ms"`
+	TCPConnection    float64 `json:"tcp_connection_ms"`
+	TLSHandshake     float64 `json:"tls_handshake_ms"`
+	ServerProcessing float64 `json:"server_processing_ms"`
+	ContentTransfer  float64 `json:"content_transfer_ms"`
+	Total            float64 `json:"total_ms"`
+}
+
+// HTTPStatResult holds the outcome of an HTTP request.
+type HTTPStatResult struct {
+	URL           string            `json:"url"`
+	Method        string            `json:"method"`
+	Protocol      string            `json:"protocol"`
+	StatusCode    int               `json:"status_code"`
+	RemoteAddress string            `json:"remote_address,omitempty"`
+	Headers       map[string]string `json:"headers"`
+	BodySize      int64             `json:"body_size"`
+	BodyTruncated bool              `json:"body_truncated,omitempty"`
+	BodySHA256    string            `json:"body_sha256"`
+	Redirects     []HTTPRedirect    `json:"redirects"`
+	Timings       HTTPTimings       `json:"timings"`
+}
+
+func (r *HTTPStatResult) String() string {
+	var b strings.Builder
+	for _, redirect := range r.Redirects {
+		fmt.Fprintf(&b, "%d %s -> %s\n", redirect.StatusCode, redirect.URL, redirect.Location)
+	}
+	fmt.Fprintf(&b, "%s %s %s %d from %s, %d bytes (sha256 %s)\n", r.Protocol, r.Method, r.URL, r.StatusCode, r.RemoteAddress, r.BodySize, r.BodySHA256)
+	fmt.Fprintf(&b,
+		"DNS lookup: %.3f ms\n"+
+			"TCP connection: %.3f ms\n"+
+			"TLS handshake: %.3f ms\n"+
+			"Server processing: %.3f ms\n"+
+			"Content transfer: %.3f ms\n"+
+			"Total: %.3f ms\n",
+		r.Timings.DNSLookup,
+		r.Timings.TCPConnection,
+		r.Timings.TLSHandshake,
+		r.Timings.ServerProcessing,
+		r.Timings.ContentTransfer,
+		r.Timings.Total,
+	)
+	return b.String()
+}
+
+// Latency is the total time of the final request.
+func (r *HTTPStatResult) Latency() (float64, bool) {
+	return r.Timings.Total, true
+}
+
+// headerFlags collects repeated -H options.
+type headerFlags []string
+
+func (h *headerFlags) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headerFlags) Set(value string) error {
+	if !strings.Contains(value, ":") {
+		return fmt.Errorf("The header must be in the 'Name: value' format: '%s'", value)
+	}
+	*h = append(*h, value)
+	return nil
+}
+
+type httpOptions struct {
+	method       string
+	headers      http.Header
+	body         string
+	maxRedirects int
+	http1        bool
+	http2        bool
+	insecure     bool
+	timeout      time.Duration
+	maxBody      int64
+	capture      []string
+	url          string
+}
+
+// parseHTTPArguments accepts a curl-like command line:
+// [-X method] [-H 'Name: value']... [-d body] [-max-redirs n]
+// [-http1.1|-http2] [-k] [-m timeout] [-max-body bytes] [-capture names] URL
+func parseHTTPArguments(args string) (*httpOptions, error) {
+	opts := &httpOptions{headers: make(http.Header)}
+
+	var headers headerFlags
+	var capture string
+	var timeout float64
+	fs := newFlagSet("httpstat")
+	fs.StringVar(&opts.method, "X", "", "request method, GET by default or POST with a body")
+	fs.Var(&headers, "H", "request header, may be repeated")
+	fs.StringVar(&opts.body, "d", "", "request body")
+	fs.IntVar(&opts.maxRedirects, "max-redirs", 10, "maximum number of redirects to follow, 0 to disable")
+	fs.BoolVar(&opts.http1, "http1.1", false, "force HTTP/1.1")
+	fs.BoolVar(&opts.http2, "http2", false, "force HTTP/2")
+	fs.BoolVar(&opts.insecure, "k", false, "do not verify the server certificate")
+	fs.Float64Var(&timeout, "m", 30, "seconds allowed for the whole request")
+	fs.Int64Var(&opts.maxBody, "max-body", 10<<20, "maximum number of body bytes to read")
+	fs.StringVar(&capture, "capture", strings.Join(defaultCapturedHeaders, ","), "a comma delimited list of response headers to record")
+
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return nil, err
+	}
+	if len(positional) != 1 {
+		return nil, errors.New("The arguments must contain exactly one URL.")
+	}
+	opts.url = positional[0]
+
+	u, err := url.Parse(opts.url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.New("The arguments must contain URL starts with either 'http://' or 'https://'.")
+	}
+
+	if opts.method == "" {
+		opts.method = http.MethodGet
+		if opts.body != "" {
+			opts.method = http.MethodPost
+		}
+	}
+	opts.method = strings.ToUpper(opts.method)
+	switch opts.method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions:
+	default:
+		return nil, fmt.Errorf("The request method is not supported: '%s'", opts.method)
+	}
+
+	for _, h := range headers {
+		parts := strings.SplitN(h, ":", 2)
+		opts.headers.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	}
+
+	for _, name := range strings.Split(capture, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			opts.capture = append(opts.capture, http.CanonicalHeaderKey(name))
+		}
+	}
+
+	if opts.http1 && opts.http2 {
+		return nil, errors.New("The -http1.1 and -http2 options are mutually exclusive.")
+	}
+	if opts.http2 && u.Scheme != "https" {
+		return nil, errors.New("HTTP/2 can only be forced on 'https://' URLs.")
+	}
+	if opts.maxRedirects < 0 || opts.maxRedirects > 30 {
+		return nil, fmt.Errorf("The maximum number of redirects must be between 0 and 30: %d", opts.maxRedirects)
+	}
+	if opts.maxBody < 0 {
+		return nil, fmt.Errorf("The maximum body size cannot be negative: %d", opts.maxBody)
+	}
+	if timeout < 0.1 || timeout > 120 {
+		return nil, fmt.Errorf("The timeout must be between 0.1 and 120 seconds: %g", timeout)
+	}
+	opts.timeout = seconds(timeout)
+
+	return opts, nil
+}
+
+// httpTracer records the phases of the last request issued with it, so
+// that only the final hop of a redirect chain is reported. The callbacks
+// run on the goroutines dialling the connection, several of them at once
+// when both IPv4 and IPv6 addresses are tried, so every field is guarded
+// by mu.
+type httpTracer struct {
+	mu                                                  sync.Mutex
+	start, dnsStart, dnsDone, connectStart, connectDone time.Time
+	tlsStart, tlsDone, wroteRequest, firstByte          time.Time
+	// connected records when each address was connected to, so that only
+	// the connection used for the request is reported.
+	connected     map[string]time.Time
+	remoteAddress string
+}
+
+// now sets field to the current time.
+func (t *httpTracer) now(field *time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	*field = time.Now()
+}
+
+func (t *httpTracer) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GetConn: func(string) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.start = time.Now()
+			t.dnsStart, t.dnsDone, t.connectStart, t.connectDone = time.Time{}, time.Time{}, time.Time{}, time.Time{}
+			t.tlsStart, t.tlsDone, t.wroteRequest, t.firstByte = time.Time{}, time.Time{}, time.Time{}, time.Time{}
+			t.connected = make(map[string]time.Time)
+			t.remoteAddress = ""
+		},
+		DNSStart: func(httptrace.DNSStartInfo) { t.now(&t.dnsStart) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { t.now(&t.dnsDone) },
+		ConnectStart: func(string, string) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			if t.connectStart.IsZero() {
+				t.connectStart = time.Now()
+			}
+		},
+		ConnectDone: func(network, addr string, err error) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			if err == nil {
+				t.connected[addr] = time.Now()
+			}
+		},
+		TLSHandshakeStart: func() { t.now(&t.tlsStart) },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { t.now(&t.tlsDone) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.remoteAddress = info.Conn.RemoteAddr().String()
+			t.connectDone = t.connected[t.remoteAddress]
+		},
+		WroteRequest:         func(httptrace.WroteRequestInfo) { t.now(&t.wroteRequest) },
+		GotFirstResponseByte: func() { t.now(&t.firstByte) },
+	}
+}
+
+// timings returns the duration of each phase of the last request, which
+// ended at end, and the address it was sent to.
+func (t *httpTracer) timings(end time.Time) (HTTPTimings, string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return HTTPTimings{
+		DNSLookup:        span(t.dnsStart, t.dnsDone),
+		TCPConnection:    span(t.connectStart, t.connectDone),
+		TLSHandshake:     span(t.tlsStart, t.tlsDone),
+		ServerProcessing: span(t.wroteRequest, t.firstByte),
+		ContentTransfer:  span(t.firstByte, end),
+		Total:            span(t.start, end),
+	}, t.remoteAddress
+}
+
+// span returns the duration between two trace events, or zero if either
+// did not happen.
+func span(from, to time.Time) float64 {
+	if from.IsZero() || to.IsZero() {
+		return 0
+	}
+	return milliseconds(to.Sub(from))
+}
+
+// newHTTPTransport returns a transport that never reuses connections, so
+// that every request pays and reports the full connection setup.
+func newHTTPTransport(opts *httpOptions) *http.Transport {
+	transport := &http.Transport{
+		Proxy:               nil,
+		DialContext:         (&net.Dialer{Control: checkDialAddress}).DialContext,
+		DisableKeepAlives:   true,
+		ForceAttemptHTTP2:   true,
+		TLSHandshakeTimeout: opts.timeout,
+		TLSClientConfig:     &tls.Config{InsecureSkipVerify: opts.insecure},
+	}
+	if opts.http1 {
+		// A non-nil, empty map disables HTTP/2.
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+	if opts.http2 {
+		transport.TLSClientConfig.NextProtos = []string{"h2"}
+	}
+	return transport
+}
+
+type httpStatProbe struct{}
+
+func (p *httpStatProbe) Validate(args string) error {
+	opts, err := parseHTTPArguments(args)
+	if err != nil {
+		return err
+	}
+	u, err := url.Parse(opts.url)
+	if err != nil {
+		return fmt.Errorf("url.Parse -> %w", err)
+	}
+	return targetPolicy.CheckHost(u.Hostname())
+}
+
+func (p *httpStatProbe) Run(ctx context.Context, args string) (Result, error) {
+	opts, err := parseHTTPArguments(args)
+	if err != nil {
+		return nil, err
+	}
+
+	var body io.Reader
+	if opts.body != "" {
+		body = strings.NewReader(opts.body)
+	}
+	req, err := http.NewRequestWithContext(ctx, opts.method, opts.url, body)
+	if err != nil {
+		return nil, fmt.Errorf("http.NewRequest -> %w", err)
+	}
+	for name, values := range opts.headers {
+		for _, v := range values {
+			req.Header.Add(name, v)
+		}
+	}
+	if host := opts.headers.Get("Host"); host != "" {
+		req.Host = host
+	}
+
+	tracer := &httpTracer{}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), tracer.clientTrace()))
+
+	result := &HTTPStatResult{
+		Method:    opts.method,
+		Headers:   make(map[string]string),
+		Redirects: make([]HTTPRedirect, 0),
+	}
+
+	transport := newHTTPTransport(opts)
+	defer transport.CloseIdleConnections()
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   opts.timeout,
+		CheckRedirect: func(next *http.Request, via []*http.Request) error {
+			result.Redirects = append(result.Redirects, HTTPRedirect{
+				URL:        via[len(via)-1].URL.String(),
+				StatusCode: next.Response.StatusCode,
+				Location:   next.URL.String(),
+			})
+			// The address is checked again when the redirect is dialled.
+			if err := targetPolicy.CheckHost(next.URL.Hostname()); err != nil {
+				return err
+			}
+			if len(via) > opts.maxRedirects {
+				return http.ErrUseLastResponse
+			}
+			return nil
+		},
+	}
+
+	if err := targetPolicy.CheckHost(req.URL.Hostname()); err != nil {
+		return nil, err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("client.Do -> %w", err)
+	}
+	defer res.Body.Close()
+
+	if len(result.Redirects) > opts.maxRedirects {
+		// The last redirect was not followed.
+		result.Redirects = result.Redirects[:opts.maxRedirects]
+	}
+
+	if opts.http2 && res.ProtoMajor != 2 {
+		return nil, fmt.Errorf("The server did not negotiate HTTP/2: %s", res.Proto)
+	}
+
+	hash := sha256.New()
+	n, err := io.Copy(hash, io.LimitReader(res.Body, opts.maxBody))
+	if err != nil {
+		return nil, fmt.Errorf("io.Copy -> %w", err)
+	}
+	end := time.Now()
+	if extra, _ := res.Body.Read(make([]byte, 1)); extra > 0 {
+		result.BodyTruncated = true
+	}
+
+	result.URL = res.Request.URL.String()
+	result.Protocol = res.Proto
+	result.StatusCode = res.StatusCode
+	result.Timings, result.RemoteAddress = tracer.timings(end)
+	result.BodySize = n
+	result.BodySHA256 = hex.EncodeToString(hash.Sum(nil))
+	for _, name := range opts.capture {
+		if v := res.Header.Values(name); len(v) > 0 {
+			result.Headers[name] = strings.Join(v, ", ")
+		}
+	}
+
+	return result, nil
+}
+
+func init() {
+	Register("httpstat", &httpStatProbe{})
+}
@@ -0,0 +1,33 @@
+package probes
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPStatRedirects(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/old", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/new", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	p, _ := Lookup("httpstat")
+	result, err := p.Run(context.Background(), server.URL+"/old")
+	assert.Nil(t, err, "The request must succeed.")
+
+	r := result.(*HTTPStatResult)
+	assert.Equal(t, 200, r.StatusCode, "The redirect must be followed.")
+	assert.Len(t, r.Redirects, 1, "The redirect must be recorded.")
+	assert.Equal(t, server.Listener.Addr().String(), r.RemoteAddress, "The address of the final connection must be recorded.")
+	assert.Greater(t, r.Timings.TCPConnection, 0.0, "The connection of the final request must be timed.")
+	assert.Equal(t, int64(5), r.BodySize, "The body must be read.")
+}
@@ -0,0 +1,135 @@
+package probes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+var (
+	randomMu sync.Mutex
+	// random is seeded, unlike the default source of math/rand, so that
+	// the choices made by the probes differ between runs.
+	random = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	// echoIDs hands out the identifiers of the ICMP echo requests, so that
+	// probes running concurrently never take each other's replies. It
+	// starts at random, since other processes may use the same socket.
+	echoIDs = uint32(randomIntn(1 << 16))
+)
+
+// randomIntn returns a random number in [0, n).
+func randomIntn(n int) int {
+	randomMu.Lock()
+	defer randomMu.Unlock()
+	return random.Intn(n)
+}
+
+// nextEchoID returns an ICMP echo identifier that no other probe of the
+// instance is using, unless 65536 of them run at once.
+func nextEchoID() int {
+	return int(atomic.AddUint32(&echoIDs, 1) & 0xffff)
+}
+
+// resolveIP resolves host to a single address of the requested family.
+// network is one of "ip", "ip4" or "ip6"; with "ip", IPv4 is preferred.
+// Both the hostname and the address must be allowed by the target policy.
+func resolveIP(ctx context.Context, network, host string) (net.IP, error) {
+	if err := targetPolicy.CheckHost(host); err != nil {
+		return nil, err
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		if network == "ip4" && ip.To4() == nil {
+			return nil, fmt.Errorf("'%s' is not an IPv4 address", host)
+		}
+		if network == "ip6" && ip.To4() != nil {
+			return nil, fmt.Errorf("'%s' is not an IPv6 address", host)
+		}
+		return ip, nil
+	}
+
+	ips, err := net.DefaultResolver.LookupIP(ctx, network, host)
+	if err != nil {
+		return nil, fmt.Errorf("net.DefaultResolver.LookupIP -> %w", err)
+	}
+	ip := ips[0]
+	for _, candidate := range ips {
+		if candidate.To4() != nil {
+			ip = candidate
+			break
+		}
+	}
+	if err := targetPolicy.CheckIP(ip); err != nil {
+		return nil, err
+	}
+	return ip, nil
+}
+
+// sourceAddress returns the local address that packets towards dst are
+// sent from. Connecting a UDP socket does not send anything.
+func sourceAddress(dst net.IP) (net.IP, error) {
+	conn, err := net.Dial("udp", net.JoinHostPort(dst.String(), "9"))
+	if err != nil {
+		return nil, fmt.Errorf("net.Dial -> %w", err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+// ipNetwork maps the -4/-6 switches of a probe to a resolver network.
+func ipNetwork(ipv4, ipv6 bool) (string, error) {
+	switch {
+	case ipv4 && ipv6:
+		return "", errors.New("The -4 and -6 options are mutually exclusive.")
+	case ipv4:
+		return "ip4", nil
+	case ipv6:
+		return "ip6", nil
+	default:
+		return "ip", nil
+	}
+}
+
+// closeOnDone closes c as soon as ctx is done, unblocking any pending read.
+// The returned function must be called to release the watcher.
+func closeOnDone(ctx context.Context, c io.Closer) func() {
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-stop:
+		}
+	}()
+	return func() { close(stop) }
+}
+
+// classifyNetworkError maps a dial or read error to a coarse class.
+func classifyNetworkError(err error) string {
+	var netErr net.Error
+	var dnsErr *net.DNSError
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "refused"
+	case errors.Is(err, syscall.ECONNRESET):
+		return "reset"
+	case errors.Is(err, syscall.EHOSTUNREACH) || errors.Is(err, syscall.ENETUNREACH):
+		return "unreachable"
+	case errors.As(err, &dnsErr):
+		return "dns"
+	default:
+		return "other"
+	}
+}
@@ -0,0 +1,150 @@
+package probes
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+type calibrationKey struct{}
+
+// Calibration collects the platform overhead of the current invocation, so
+// that the null probe can report it. All methods are safe to call on a nil
+// Calibration.
+type Calibration struct {
+	// ScheduleTime is when Cloud Scheduler fired the job, if known.
+	ScheduleTime time.Time
+	// HandlerStart is when the measurement handler started.
+	HandlerStart time.Time
+	// InstanceStart is when the serving instance started.
+	InstanceStart time.Time
+	// InstanceRequests is the number of requests served by the instance so
+	// far, including the current one.
+	InstanceRequests int64
+
+	mu              sync.Mutex
+	firestoreReads  []time.Duration
+	firestoreWrites []time.Duration
+}
+
+// WithCalibration returns a copy of ctx carrying c.
+func WithCalibration(ctx context.Context, c *Calibration) context.Context {
+	return context.WithValue(ctx, calibrationKey{}, c)
+}
+
+// CalibrationFrom returns the calibration carried by ctx, or nil.
+func CalibrationFrom(ctx context.Context) *Calibration {
+	c, _ := ctx.Value(calibrationKey{}).(*Calibration)
+	return c
+}
+
+// ColdStart reports whether the current request is the first one served
+// by the instance.
+func (c *Calibration) ColdStart() bool {
+	return c != nil && c.InstanceRequests == 1
+}
+
+// AddFirestoreRead records the latency of a Firestore read.
+func (c *Calibration) AddFirestoreRead(d time.Duration) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.firestoreReads = append(c.firestoreReads, d)
+}
+
+// AddFirestoreWrite records the latency of a Firestore write.
+func (c *Calibration) AddFirestoreWrite(d time.Duration) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.firestoreWrites = append(c.firestoreWrites, d)
+}
+
+// FirestoreReads returns the latencies of the Firestore reads so far.
+func (c *Calibration) FirestoreReads() []time.Duration {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]time.Duration(nil), c.firestoreReads...)
+}
+
+// FirestoreWrites returns the latencies of the Firestore writes so far.
+func (c *Calibration) FirestoreWrites() []time.Duration {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]time.Duration(nil), c.firestoreWrites...)
+}
+
+// NullResult reports the platform overhead observed up to the moment the
+// null probe ran.
+type NullResult struct {
+	TriggerDelay     float64   `json:"trigger_delay_ms,omitempty"`
+	HandlerDelay     float64   `json:"handler_delay_ms"`
+	FirestoreReads   []float64 `json:"firestore_reads_ms"`
+	FirestoreWrites  []float64 `json:"firestore_writes_ms"`
+	ColdStart        bool      `json:"cold_start"`
+	InstanceRequests int64     `json:"instance_requests"`
+	InstanceUptime   float64   `json:"instance_uptime_ms"`
+}
+
+func (r *NullResult) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Trigger delay: %.3f ms\n", r.TriggerDelay)
+	fmt.Fprintf(&b, "Handler delay: %.3f ms\n", r.HandlerDelay)
+	fmt.Fprintf(&b, "Firestore reads: %v ms\n", r.FirestoreReads)
+	fmt.Fprintf(&b, "Firestore writes: %v ms\n", r.FirestoreWrites)
+	fmt.Fprintf(&b, "Cold start: %t (request %d, instance up for %.3f ms)\n", r.ColdStart, r.InstanceRequests, r.InstanceUptime)
+	return b.String()
+}
+
+// nullProbe performs no network I/O. It reports the overhead of the
+// platform itself, to be subtracted from real measurements.
+type nullProbe struct{}
+
+func (p *nullProbe) Validate(args string) error {
+	return nil
+}
+
+func (p *nullProbe) Run(ctx context.Context, args string) (Result, error) {
+	now := time.Now()
+	result := &NullResult{
+		FirestoreReads:  make([]float64, 0),
+		FirestoreWrites: make([]float64, 0),
+	}
+
+	c := CalibrationFrom(ctx)
+	if c == nil {
+		return result, nil
+	}
+
+	if !c.ScheduleTime.IsZero() {
+		result.TriggerDelay = milliseconds(c.HandlerStart.Sub(c.ScheduleTime))
+	}
+	result.HandlerDelay = milliseconds(now.Sub(c.HandlerStart))
+	for _, d := range c.FirestoreReads() {
+		result.FirestoreReads = append(result.FirestoreReads, milliseconds(d))
+	}
+	for _, d := range c.FirestoreWrites() {
+		result.FirestoreWrites = append(result.FirestoreWrites, milliseconds(d))
+	}
+	result.ColdStart = c.ColdStart()
+	result.InstanceRequests = c.InstanceRequests
+	result.InstanceUptime = milliseconds(c.HandlerStart.Sub(c.InstanceStart))
+
+	return result, nil
+}
+
+func init() {
+	Register("null", &nullProbe{})
+}
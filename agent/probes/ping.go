@@ -0,0 +1,356 @@
+package probes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	protocolICMP     = 1
+	protocolIPv6ICMP = 58
+)
+
+// PingPacket is the outcome of a single ICMP echo request.
+type PingPacket struct {
+	Sequence int     `json:"seq"`
+	Received bool    `json:"received"`
+	RTT      float64 `json:"rtt_ms,omitempty"`
+	TTL      int     `json:"ttl,omitempty"`
+	Size     int     `json:"size,omitempty"`
+}
+
+// PingResult holds the outcome of an ICMP echo measurement.
+type PingResult struct {
+	Target      string         `json:"target"`
+	Address     string         `json:"address"`
+	PacketSize  int            `json:"packet_size"`
+	Transmitted int            `json:"transmitted"`
+	Received    int            `json:"received"`
+	Loss        float64        `json:"loss_percent"`
+	RTT         *RTTStatistics `json:"rtt,omitempty"`
+	Packets     []PingPacket   `json:"packets"`
+}
+
+func (r *PingResult) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "PING %s (%s): %d data bytes\n", r.Target, r.Address, r.PacketSize)
+	for _, p := range r.Packets {
+		if p.Received {
+			fmt.Fprintf(&b, "%d bytes from %s: seq=%d ttl=%d time=%.3f ms\n", p.Size, r.Address, p.Sequence, p.TTL, p.RTT)
+		}
+	}
+	fmt.Fprintf(&b, "\n--- %s ping statistics ---\n", r.Target)
+	fmt.Fprintf(&b, "%d packets transmitted, %d packets received, %g%% packet loss\n", r.Transmitted, r.Received, r.Loss)
+	if r.RTT != nil {
+		fmt.Fprintf(&b, "round-trip min/avg/max/mdev = %.3f/%.3f/%.3f/%.3f ms\n", r.RTT.Min, r.RTT.Avg, r.RTT.Max, r.RTT.MDev)
+	}
+	return b.String()
+}
+
+// Latency is the average round-trip time of the replies received.
+func (r *PingResult) Latency() (float64, bool) {
+	if r.RTT == nil {
+		return 0, false
+	}
+	return r.RTT.Avg, true
+}
+
+type pingOptions struct {
+	count    int
+	interval time.Duration
+	timeout  time.Duration
+	size     int
+	ttl      int
+	network  string
+	host     string
+}
+
+// parsePingArguments accepts a subset of the ping command line:
+// [-4|-6] [-c count] [-i interval] [-s size] [-t ttl] [-W timeout] host
+func parsePingArguments(args string) (*pingOptions, error) {
+	opts := &pingOptions{}
+
+	var interval, timeout float64
+	var ipv4, ipv6 bool
+	fs := newFlagSet("ping")
+	fs.IntVar(&opts.count, "c", 1, "number of echo requests to send")
+	fs.Float64Var(&interval, "i", 1, "seconds between echo requests")
+	fs.IntVar(&opts.size, "s", 56, "number of data bytes in each echo request")
+	fs.IntVar(&opts.ttl, "t", 64, "IP time to live of the echo requests")
+	fs.Float64Var(&timeout, "W", 1, "seconds to wait for each echo reply")
+	fs.BoolVar(&ipv4, "4", false, "use IPv4 only")
+	fs.BoolVar(&ipv6, "6", false, "use IPv6 only")
+
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return nil, err
+	}
+	if len(positional) != 1 {
+		return nil, errors.New("The arguments must contain exactly one destination host.")
+	}
+	opts.host = positional[0]
+
+	if opts.network, err = ipNetwork(ipv4, ipv6); err != nil {
+		return nil, err
+	}
+	if opts.count < 1 || opts.count > 100 {
+		return nil, fmt.Errorf("The count must be between 1 and 100: %d", opts.count)
+	}
+	if interval < 0.01 || interval > 10 {
+		return nil, fmt.Errorf("The interval must be between 0.01 and 10 seconds: %g", interval)
+	}
+	if timeout < 0.1 || timeout > 30 {
+		return nil, fmt.Errorf("The timeout must be between 0.1 and 30 seconds: %g", timeout)
+	}
+	if opts.size < 0 || opts.size > 65400 {
+		return nil, fmt.Errorf("The packet size must be between 0 and 65400 bytes: %d", opts.size)
+	}
+	if opts.ttl < 1 || opts.ttl > 255 {
+		return nil, fmt.Errorf("The TTL must be between 1 and 255: %d", opts.ttl)
+	}
+	opts.interval = seconds(interval)
+	opts.timeout = seconds(timeout)
+
+	return opts, nil
+}
+
+// icmpConn wraps an ICMP socket of either family, unprivileged (datagram)
+// when the kernel allows it and raw otherwise.
+type icmpConn struct {
+	*icmp.PacketConn
+	v4         *ipv4.PacketConn
+	v6         *ipv6.PacketConn
+	privileged bool
+}
+
+// listenICMP opens an ICMP socket matching the family of ip.
+func listenICMP(ip net.IP) (*icmpConn, error) {
+	datagram, raw, address := "udp4", "ip4:icmp", "0.0.0.0"
+	if ip.To4() == nil {
+		datagram, raw, address = "udp6", "ip6:ipv6-icmp", "::"
+	}
+
+	c := &icmpConn{}
+	conn, err := icmp.ListenPacket(datagram, address)
+	if err != nil {
+		conn, err = icmp.ListenPacket(raw, address)
+		if err != nil {
+			return nil, fmt.Errorf("icmp.ListenPacket -> %w", err)
+		}
+		c.privileged = true
+	}
+	c.PacketConn = conn
+
+	if ip.To4() != nil {
+		c.v4 = conn.IPv4PacketConn()
+		c.v4.SetControlMessage(ipv4.FlagTTL, true)
+	} else {
+		c.v6 = conn.IPv6PacketConn()
+		c.v6.SetControlMessage(ipv6.FlagHopLimit, true)
+	}
+	return c, nil
+}
+
+// setTTL sets the TTL (or hop limit) of outgoing packets.
+func (c *icmpConn) setTTL(ttl int) error {
+	if c.v4 != nil {
+		return c.v4.SetTTL(ttl)
+	}
+	return c.v6.SetHopLimit(ttl)
+}
+
+// destination returns the address type expected by WriteTo.
+func (c *icmpConn) destination(ip net.IP) net.Addr {
+	if c.privileged {
+		return &net.IPAddr{IP: ip}
+	}
+	return &net.UDPAddr{IP: ip}
+}
+
+// read receives one ICMP message, returning its received TTL and sender.
+func (c *icmpConn) read(b []byte) (*icmp.Message, int, net.IP, error) {
+	var n, ttl int
+	var peer net.Addr
+	var err error
+	if c.v4 != nil {
+		var cm *ipv4.ControlMessage
+		n, cm, peer, err = c.v4.ReadFrom(b)
+		if cm != nil {
+			ttl = cm.TTL
+		}
+	} else {
+		var cm *ipv6.ControlMessage
+		n, cm, peer, err = c.v6.ReadFrom(b)
+		if cm != nil {
+			ttl = cm.HopLimit
+		}
+	}
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	proto := protocolICMP
+	if c.v6 != nil {
+		proto = protocolIPv6ICMP
+	}
+	msg, err := icmp.ParseMessage(proto, b[:n])
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("icmp.ParseMessage -> %w", err)
+	}
+
+	var from net.IP
+	switch addr := peer.(type) {
+	case *net.IPAddr:
+		from = addr.IP
+	case *net.UDPAddr:
+		from = addr.IP
+	}
+	return msg, ttl, from, nil
+}
+
+type pingProbe struct{}
+
+func (p *pingProbe) Validate(args string) error {
+	opts, err := parsePingArguments(args)
+	if err != nil {
+		return err
+	}
+	return targetPolicy.CheckHost(opts.host)
+}
+
+// Check opens and closes an ICMP socket, since pings cannot be sent
+// without one.
+func (p *pingProbe) Check() error {
+	conn, err := listenICMP(net.IPv4zero)
+	if err != nil {
+		return fmt.Errorf("listenICMP -> %w", err)
+	}
+	return conn.Close()
+}
+
+func (p *pingProbe) Run(ctx context.Context, args string) (Result, error) {
+	opts, err := parsePingArguments(args)
+	if err != nil {
+		return nil, err
+	}
+
+	ip, err := resolveIP(ctx, opts.network, opts.host)
+	if err != nil {
+		return nil, fmt.Errorf("resolveIP -> %w", err)
+	}
+
+	conn, err := listenICMP(ip)
+	if err != nil {
+		return nil, fmt.Errorf("listenICMP -> %w", err)
+	}
+	defer conn.Close()
+	defer closeOnDone(ctx, conn)()
+
+	if err := conn.setTTL(opts.ttl); err != nil {
+		return nil, fmt.Errorf("conn.setTTL -> %w", err)
+	}
+
+	var requestType, replyType icmp.Type = ipv4.ICMPTypeEcho, ipv4.ICMPTypeEchoReply
+	if ip.To4() == nil {
+		requestType, replyType = ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply
+	}
+
+	id := nextEchoID()
+	payload := make([]byte, opts.size)
+	buf := make([]byte, opts.size+512)
+
+	result := &PingResult{
+		Target:     opts.host,
+		Address:    ip.String(),
+		PacketSize: opts.size,
+		Packets:    make([]PingPacket, 0, opts.count),
+	}
+	rtts := make([]time.Duration, 0, opts.count)
+
+	for seq := 0; seq < opts.count; seq++ {
+		msg := icmp.Message{
+			Type: requestType,
+			Body: &icmp.Echo{ID: id, Seq: seq, Data: payload},
+		}
+		wb, err := msg.Marshal(nil)
+		if err != nil {
+			return nil, fmt.Errorf("msg.Marshal -> %w", err)
+		}
+
+		sent := time.Now()
+		if _, err := conn.WriteTo(wb, conn.destination(ip)); err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, fmt.Errorf("conn.WriteTo -> %w", err)
+		}
+		result.Transmitted++
+
+		packet := PingPacket{Sequence: seq}
+		conn.SetReadDeadline(sent.Add(opts.timeout))
+		for {
+			reply, ttl, from, err := conn.read(buf)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					break
+				}
+				// Only failures of the socket itself are fatal; a malformed
+				// message is skipped.
+				var opErr *net.OpError
+				if errors.As(err, &opErr) {
+					return nil, fmt.Errorf("conn.read -> %w", err)
+				}
+				continue
+			}
+			rtt := time.Since(sent)
+
+			echo, ok := reply.Body.(*icmp.Echo)
+			if reply.Type != replyType || !ok || echo.Seq != seq {
+				continue
+			}
+			// Unprivileged sockets only see their own replies, with the
+			// identifier rewritten by the kernel.
+			if conn.privileged && (echo.ID != id || !from.Equal(ip)) {
+				continue
+			}
+
+			packet.Received = true
+			packet.RTT = milliseconds(rtt)
+			packet.TTL = ttl
+			packet.Size = len(echo.Data) + 8
+			rtts = append(rtts, rtt)
+			result.Received++
+			break
+		}
+		result.Packets = append(result.Packets, packet)
+
+		if seq < opts.count-1 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(time.Until(sent.Add(opts.interval))):
+			}
+		}
+	}
+
+	result.Loss = 100 * float64(result.Transmitted-result.Received) / float64(result.Transmitted)
+	result.RTT = newRTTStatistics(rtts)
+
+	return result, nil
+}
+
+func init() {
+	Register("ping", &pingProbe{})
+}
@@ -0,0 +1,37 @@
+package probes
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPingLoopback(t *testing.T) {
+	conn, err := listenICMP(net.IPv4(127, 0, 0, 1))
+	if err != nil {
+		t.Skipf("ICMP sockets are not allowed: %v", err)
+	}
+	conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := (&pingProbe{}).Run(ctx, "-c 3 -i 0.01 -W 1 127.0.0.1")
+
+	if assert.Nil(t, err, "The loopback address must be pinged.") {
+		r := result.(*PingResult)
+		assert.Equal(t, 3, r.Transmitted, "Every echo request must be sent.")
+		assert.Equal(t, 3, r.Received, "Every echo reply must be received.")
+		assert.Equal(t, 0.0, r.Loss, "No packet must be lost.")
+		if assert.Len(t, r.Packets, 3, "Every packet must be recorded.") {
+			for i, packet := range r.Packets {
+				assert.Equal(t, i, packet.Sequence, "The packets must be recorded in order.")
+				assert.True(t, packet.Received, "Every packet must be received.")
+			}
+		}
+		assert.NotNil(t, r.RTT, "The round-trip times must be summarised.")
+	}
+}
@@ -0,0 +1,218 @@
+package probes
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// ErrTargetDenied is returned, wrapped, when the target policy does not
+// allow a probe to reach an address or a hostname.
+var ErrTargetDenied = errors.New("The target is not allowed")
+
+// defaultDeniedCIDRs keeps the probes away from the instance itself, from
+// the networks of the project, and from the metadata server.
+var defaultDeniedCIDRs = []string{
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"64:ff9b::/96",
+	// Teredo and 6to4 addresses embed an IPv4 address that relays forward
+	// to, which may be one of the denied ones.
+	"2001::/32",
+	"2002::/16",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+}
+
+// defaultDeniedHosts covers the names that resolve to the instance or to
+// the metadata server, such as metadata.google.internal.
+var defaultDeniedHosts = []string{
+	"localhost",
+	"*.localhost",
+	"*.internal",
+}
+
+// TargetPolicy decides which addresses and hostnames the probes may reach.
+// A target is denied when it matches a deny entry and no allow entry.
+type TargetPolicy struct {
+	deniedNets   []*net.IPNet
+	allowedNets  []*net.IPNet
+	deniedHosts  []string
+	allowedHosts []string
+}
+
+// NewTargetPolicy builds a policy from CIDR blocks and hostname patterns. A
+// pattern is either a hostname or "*." followed by a domain, which matches
+// every name below the domain.
+func NewTargetPolicy(deniedCIDRs, allowedCIDRs, deniedHosts, allowedHosts []string) (*TargetPolicy, error) {
+	p := &TargetPolicy{
+		deniedHosts:  normalizeHosts(deniedHosts),
+		allowedHosts: normalizeHosts(allowedHosts),
+	}
+
+	var err error
+	if p.deniedNets, err = parseCIDRs(deniedCIDRs); err != nil {
+		return nil, fmt.Errorf("parseCIDRs -> %w", err)
+	}
+	if p.allowedNets, err = parseCIDRs(allowedCIDRs); err != nil {
+		return nil, fmt.Errorf("parseCIDRs -> %w", err)
+	}
+	return p, nil
+}
+
+// DefaultTargetPolicy returns the policy denying the private, link-local
+// and metadata addresses and names.
+func DefaultTargetPolicy() *TargetPolicy {
+	p, err := NewTargetPolicy(defaultDeniedCIDRs, nil, defaultDeniedHosts, nil)
+	if err != nil {
+		panic(fmt.Sprintf("probes: invalid default target policy: %v", err))
+	}
+	return p
+}
+
+// TargetPolicyFromEnv builds a policy from the comma-separated lists in
+// TARGET_DENY_CIDRS, TARGET_ALLOW_CIDRS, TARGET_DENY_HOSTS and
+// TARGET_ALLOW_HOSTS. The deny lists default to the private, link-local
+// and metadata addresses and names when they are not set.
+func TargetPolicyFromEnv() (*TargetPolicy, error) {
+	deniedCIDRs := defaultDeniedCIDRs
+	if v, ok := os.LookupEnv("TARGET_DENY_CIDRS"); ok {
+		deniedCIDRs = splitList(v)
+	}
+	deniedHosts := defaultDeniedHosts
+	if v, ok := os.LookupEnv("TARGET_DENY_HOSTS"); ok {
+		deniedHosts = splitList(v)
+	}
+	return NewTargetPolicy(deniedCIDRs, splitList(os.Getenv("TARGET_ALLOW_CIDRS")),
+		deniedHosts, splitList(os.Getenv("TARGET_ALLOW_HOSTS")))
+}
+
+// CheckIP returns an error wrapping ErrTargetDenied if the policy does not
+// allow the address.
+func (p *TargetPolicy) CheckIP(ip net.IP) error {
+	if p == nil {
+		return nil
+	}
+	if containsIP(p.allowedNets, ip) || !containsIP(p.deniedNets, ip) {
+		return nil
+	}
+	return fmt.Errorf("%w: '%s'", ErrTargetDenied, ip)
+}
+
+// CheckHost returns an error wrapping ErrTargetDenied if the policy does not
+// allow the hostname, or the address when host is an IP literal. Hostnames
+// must still be checked again once resolved.
+func (p *TargetPolicy) CheckHost(host string) error {
+	if p == nil {
+		return nil
+	}
+	if ip := net.ParseIP(strings.Trim(host, "[]")); ip != nil {
+		return p.CheckIP(ip)
+	}
+
+	name := normalizeHost(host)
+	if matchHost(p.allowedHosts, name) || !matchHost(p.deniedHosts, name) {
+		return nil
+	}
+	return fmt.Errorf("%w: '%s'", ErrTargetDenied, host)
+}
+
+// targetPolicy is enforced by every probe. It is nil, allowing everything,
+// until SetTargetPolicy is called.
+var targetPolicy *TargetPolicy
+
+// SetTargetPolicy sets the policy enforced by the probes. It must be called
+// before any probe runs.
+func SetTargetPolicy(p *TargetPolicy) {
+	targetPolicy = p
+}
+
+// checkDialAddress is a net.Dialer Control function that enforces the
+// target policy on the address actually dialled, after name resolution and
+// on every redirect.
+func checkDialAddress(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("net.SplitHostPort -> %w", err)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("The dialled address is not an IP address: '%s'", address)
+	}
+	return targetPolicy.CheckIP(ip)
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("net.ParseCIDR -> %w", err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// containsIP reports whether ip is in any of nets. IPv4-mapped IPv6
+// addresses are compared as IPv4 addresses.
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchHost(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if pattern == name {
+			return true
+		}
+		if strings.HasPrefix(pattern, "*.") && strings.HasSuffix(name, pattern[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+}
+
+func normalizeHosts(hosts []string) []string {
+	normalized := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		normalized = append(normalized, normalizeHost(host))
+	}
+	return normalized
+}
+
+func splitList(s string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,69 @@
+package probes
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultTargetPolicy(t *testing.T) {
+	p := DefaultTargetPolicy()
+
+	for _, host := range []string{"169.254.169.254", "10.1.2.3", "127.0.0.1", "::1", "fd00:ec2::254", "::ffff:10.0.0.1", "2002:a9fe:a9fe::1", "2001:0:4136:e378:8000:63bf:3fff:fdd2", "metadata.google.internal", "LOCALHOST."} {
+		err := p.CheckHost(host)
+		assert.True(t, errors.Is(err, ErrTargetDenied), "The target must be denied: %s", host)
+	}
+	for _, host := range []string{"8.8.8.8", "2001:4860:4860::8888", "google.com"} {
+		assert.Nil(t, p.CheckHost(host), "The target must be allowed: %s", host)
+	}
+}
+
+func TestTargetPolicyAllowList(t *testing.T) {
+	p, err := NewTargetPolicy([]string{"10.0.0.0/8"}, []string{"10.1.0.0/16"}, []string{"*.example.com"}, []string{"www.example.com"})
+
+	assert.Nil(t, err, "A valid policy must be built without error.")
+	assert.Nil(t, p.CheckIP(net.ParseIP("10.1.2.3")), "An allowed block must take precedence over a denied one.")
+	assert.NotNil(t, p.CheckIP(net.ParseIP("10.2.0.1")), "The rest of the denied block must be denied.")
+	assert.Nil(t, p.CheckHost("www.example.com"), "An allowed name must take precedence over a denied pattern.")
+	assert.NotNil(t, p.CheckHost("api.example.com"), "The names below a denied domain must be denied.")
+	assert.Nil(t, p.CheckHost("example.com"), "A wildcard must not match the domain itself.")
+}
+
+func TestTargetPolicyInvalidCIDR(t *testing.T) {
+	_, err := NewTargetPolicy([]string{"10.0.0.0/33"}, nil, nil, nil)
+
+	assert.NotNil(t, err, "An invalid CIDR block must be rejected.")
+}
+
+func TestValidateChecksTargetPolicy(t *testing.T) {
+	SetTargetPolicy(DefaultTargetPolicy())
+	defer SetTargetPolicy(nil)
+
+	denied := map[string]string{
+		"ping":       "169.254.169.254",
+		"traceroute": "-M tcp 10.0.0.1",
+		"tcp":        "127.0.0.1:22",
+		"tls":        "metadata.google.internal",
+		"httpstat":   "http://169.254.169.254/computeMetadata/v1/",
+		"curl":       "-L http://localhost:8080/",
+		"dns":        "-s 10.0.0.2 google.com",
+	}
+	for name, args := range denied {
+		probe, _ := Lookup(name)
+		err := probe.Validate(args)
+		assert.True(t, errors.Is(err, ErrTargetDenied), "The target must be denied: %s %s", name, args)
+	}
+
+	allowed := map[string]string{
+		"ping":     "google.com",
+		"httpstat": "https://google.com/",
+		"curl":     "-L https://google.com/",
+		"dns":      "google.com",
+	}
+	for name, args := range allowed {
+		probe, _ := Lookup(name)
+		assert.Nil(t, probe.Validate(args), "The target must be allowed: %s %s", name, args)
+	}
+}
@@ -0,0 +1,91 @@
+package probes
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Result is the outcome of a single probe execution.
+type Result interface {
+	// String renders the result in the textual format stored in Task.Result.
+	String() string
+}
+
+// Probe is a measurement method that can be executed by the agent.
+type Probe interface {
+	// Validate checks the task arguments without performing any measurement.
+	// A target denied by the target policy is reported with an error wrapping
+	// ErrTargetDenied; hostnames are checked again once resolved by Run.
+	Validate(args string) error
+
+	// Run performs the measurement described by args.
+	Run(ctx context.Context, args string) (Result, error)
+}
+
+// Checker is implemented by probes that depend on the instance they run on,
+// such as on an external binary or on a raw socket.
+type Checker interface {
+	// Check reports whether the probe can run on this instance.
+	Check() error
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Probe)
+)
+
+// Register makes a probe available under the given name. It panics if the
+// name is empty or already taken, since that is a programming error.
+func Register(name string, p Probe) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if name == "" || p == nil {
+		panic("probes: Register called with an empty name or a nil probe")
+	}
+	if _, exists := registry[name]; exists {
+		panic(fmt.Sprintf("probes: Register called twice for probe %q", name))
+	}
+	registry[name] = p
+}
+
+// Lookup returns the probe registered under the given name.
+func Lookup(name string) (Probe, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	p, ok := registry[name]
+	return p, ok
+}
+
+// Names returns the sorted names of all registered probes.
+func Names() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Check runs the check of every registered probe that has one, and returns
+// the errors by probe name.
+func Check() map[string]error {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	failed := make(map[string]error)
+	for name, p := range registry {
+		if c, ok := p.(Checker); ok {
+			if err := c.Check(); err != nil {
+				failed[name] = err
+			}
+		}
+	}
+	return failed
+}
@@ -0,0 +1,96 @@
+package probes
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// sandboxArg is the first argument of the agent when it runs as the sandbox
+// of a command, followed by the path of the command and its arguments.
+const sandboxArg = "-measurer-sandbox"
+
+// sandboxCommand returns the command running binary within the limits set
+// by RunSandbox, as the leader of a new process group so that it can be
+// killed together with every process it forks.
+func sandboxCommand(binary string, args []string) (*exec.Cmd, error) {
+	path, err := exec.LookPath(binary)
+	if err != nil {
+		return nil, fmt.Errorf("exec.LookPath -> %w", err)
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("os.Executable -> %w", err)
+	}
+
+	cmd := exec.Command(self, append([]string{sandboxArg, path}, args...)...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	return cmd, nil
+}
+
+// RunSandbox must be called at the start of main. When the agent runs as
+// the sandbox of a command, it caps the CPU time and the address space of
+// the process and replaces it with the command, which therefore starts with
+// the limits in place and passes them on to every process it forks. It
+// returns otherwise.
+func RunSandbox() {
+	if len(os.Args) < 3 || os.Args[1] != sandboxArg {
+		return
+	}
+
+	path, err := syscall.BytePtrFromString(os.Args[2])
+	if err != nil {
+		os.Exit(127)
+	}
+	argv, err := syscall.SlicePtrFromStrings(os.Args[2:])
+	if err != nil {
+		os.Exit(127)
+	}
+	envv, err := syscall.SlicePtrFromStrings(os.Environ())
+	if err != nil {
+		os.Exit(127)
+	}
+	cpu := unix.Rlimit{Cur: maxCommandCPUTime, Max: maxCommandCPUTime}
+	memory := unix.Rlimit{Cur: maxCommandMemory, Max: maxCommandMemory}
+	failed := []byte("sandbox: the limits cannot be set or the command executed\n")
+
+	// The runtime already maps more than the address space allowed to the
+	// command, so nothing may be allocated once it is limited: from here
+	// on, only raw system calls on the values prepared above are made.
+	if _, _, errno := syscall.RawSyscall6(unix.SYS_PRLIMIT64, 0, unix.RLIMIT_CPU, uintptr(unsafe.Pointer(&cpu)), 0, 0, 0); errno == 0 {
+		if _, _, errno := syscall.RawSyscall6(unix.SYS_PRLIMIT64, 0, unix.RLIMIT_AS, uintptr(unsafe.Pointer(&memory)), 0, 0, 0); errno == 0 {
+			syscall.RawSyscall(unix.SYS_EXECVE, uintptr(unsafe.Pointer(path)), uintptr(unsafe.Pointer(&argv[0])), uintptr(unsafe.Pointer(&envv[0])))
+		}
+	}
+	syscall.RawSyscall(unix.SYS_WRITE, 2, uintptr(unsafe.Pointer(&failed[0])), uintptr(len(failed)))
+	syscall.RawSyscall(unix.SYS_EXIT_GROUP, 127, 0, 0)
+}
+
+// killGroupOnExit kills the process group of a started command once the
+// command has exited, without reaping it so that the process group cannot
+// have been reused. The returned channel is closed once it is done.
+func killGroupOnExit(cmd *exec.Cmd) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var info unix.Siginfo
+		for {
+			err := unix.Waitid(unix.P_PID, cmd.Process.Pid, &info, unix.WEXITED|unix.WNOWAIT, nil)
+			if err != unix.EINTR {
+				break
+			}
+		}
+		killProcessGroup(cmd)
+	}()
+	return done
+}
+
+// killProcessGroup kills the process group of a started command, whose
+// leader must not have been reaped yet.
+func killProcessGroup(cmd *exec.Cmd) {
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,34 @@
+//go:build !linux
+
+package probes
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// The agent runs on Linux; elsewhere, commands run without a sandbox so
+// that the package can still be built and tested.
+
+func sandboxCommand(binary string, args []string) (*exec.Cmd, error) {
+	path, err := exec.LookPath(binary)
+	if err != nil {
+		return nil, fmt.Errorf("exec.LookPath -> %w", err)
+	}
+	return exec.Command(path, args...), nil
+}
+
+// RunSandbox does nothing, since commands are not sandboxed.
+func RunSandbox() {}
+
+// killGroupOnExit does nothing, since the command has no process group of
+// its own.
+func killGroupOnExit(cmd *exec.Cmd) <-chan struct{} {
+	done := make(chan struct{})
+	close(done)
+	return done
+}
+
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
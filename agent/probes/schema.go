@@ -0,0 +1,29 @@
+package probes
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// SchemaVersion is the version of the structured result documents. Version
+// 1 is the free-text format stored by earlier agents.
+const SchemaVersion = 2
+
+// Encode converts a probe result into the structured map stored in
+// Firestore, keyed by the JSON names of its fields and tagged with the
+// probe name and the schema version.
+func Encode(probe string, r Result) (map[string]interface{}, error) {
+	raw, err := json.Marshal(r)
+	if err != nil {
+		return nil, fmt.Errorf("json.Marshal -> %w", err)
+	}
+
+	data := make(map[string]interface{})
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal -> %w", err)
+	}
+
+	data["probe"] = probe
+	data["schema_version"] = SchemaVersion
+	return data, nil
+}
@@ -0,0 +1,66 @@
+package probes
+
+import (
+	"math"
+	"time"
+)
+
+// RTTStatistics summarises a set of round-trip times in milliseconds.
+type RTTStatistics struct {
+	Min  float64 `json:"min_ms"`
+	Avg  float64 `json:"avg_ms"`
+	Max  float64 `json:"max_ms"`
+	MDev float64 `json:"mdev_ms"`
+}
+
+// newRTTStatistics computes min/avg/max/mdev the same way iputils ping
+// does. It returns nil when there is no sample.
+func newRTTStatistics(rtts []time.Duration) *RTTStatistics {
+	if len(rtts) == 0 {
+		return nil
+	}
+
+	min, max := rtts[0], rtts[0]
+	var sum, sumSquares float64
+	for _, rtt := range rtts {
+		if rtt < min {
+			min = rtt
+		}
+		if rtt > max {
+			max = rtt
+		}
+		ms := milliseconds(rtt)
+		sum += ms
+		sumSquares += ms * ms
+	}
+
+	n := float64(len(rtts))
+	avg := sum / n
+	return &RTTStatistics{
+		Min:  milliseconds(min),
+		Avg:  avg,
+		Max:  milliseconds(max),
+		MDev: math.Sqrt(math.Max(sumSquares/n-avg*avg, 0)),
+	}
+}
+
+// LatencyResult is implemented by results that have a single headline
+// latency, in milliseconds, which can be compared across samples.
+type LatencyResult interface {
+	Result
+	Latency() (float64, bool)
+}
+
+// Aggregate summarises the headline latency of the results that have one.
+// It returns nil when none of them has.
+func Aggregate(results []Result) *RTTStatistics {
+	latencies := make([]time.Duration, 0, len(results))
+	for _, r := range results {
+		if lr, ok := r.(LatencyResult); ok {
+			if ms, ok := lr.Latency(); ok {
+				latencies = append(latencies, time.Duration(ms*float64(time.Millisecond)))
+			}
+		}
+	}
+	return newRTTStatistics(latencies)
+}
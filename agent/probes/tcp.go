@@ -0,0 +1,191 @@
+package probes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// TCPAttempt is the outcome of a single TCP connection attempt.
+type TCPAttempt struct {
+	Sequence      int     `json:"seq"`
+	Connected     bool    `json:"connected"`
+	ConnectTime   float64 `json:"connect_ms,omitempty"`
+	RemoteAddress string  `json:"remote_address,omitempty"`
+	LocalAddress  string  `json:"local_address,omitempty"`
+	ErrorClass    string  `json:"error_class,omitempty"`
+	Error         string  `json:"error,omitempty"`
+}
+
+// TCPResult holds the outcome of a series of TCP connection attempts.
+type TCPResult struct {
+	Target    string         `json:"target"`
+	Address   string         `json:"address"`
+	Port      string         `json:"port"`
+	Attempts  int            `json:"attempts"`
+	Connected int            `json:"connected"`
+	Loss      float64        `json:"loss_percent"`
+	RTT       *RTTStatistics `json:"connect,omitempty"`
+	Samples   []TCPAttempt   `json:"samples"`
+}
+
+func (r *TCPResult) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "TCP connect to %s (%s) port %s\n", r.Target, r.Address, r.Port)
+	for _, s := range r.Samples {
+		if s.Connected {
+			fmt.Fprintf(&b, "seq=%d connected from %s to %s time=%.3f ms\n", s.Sequence, s.LocalAddress, s.RemoteAddress, s.ConnectTime)
+		} else {
+			fmt.Fprintf(&b, "seq=%d %s: %s\n", s.Sequence, s.ErrorClass, s.Error)
+		}
+	}
+	fmt.Fprintf(&b, "%d attempts, %d connected, %g%% failed\n", r.Attempts, r.Connected, r.Loss)
+	if r.RTT != nil {
+		fmt.Fprintf(&b, "connect min/avg/max/mdev = %.3f/%.3f/%.3f/%.3f ms\n", r.RTT.Min, r.RTT.Avg, r.RTT.Max, r.RTT.MDev)
+	}
+	return b.String()
+}
+
+// Latency is the average time to establish the connections.
+func (r *TCPResult) Latency() (float64, bool) {
+	if r.RTT == nil {
+		return 0, false
+	}
+	return r.RTT.Avg, true
+}
+
+type tcpOptions struct {
+	count    int
+	interval time.Duration
+	timeout  time.Duration
+	network  string
+	host     string
+	port     string
+}
+
+// parseTCPArguments accepts [-4|-6] [-c count] [-i interval] [-W timeout]
+// host:port.
+func parseTCPArguments(args string) (*tcpOptions, error) {
+	opts := &tcpOptions{}
+
+	var interval, timeout float64
+	var ipv4, ipv6 bool
+	fs := newFlagSet("tcp")
+	fs.IntVar(&opts.count, "c", 1, "number of connection attempts")
+	fs.Float64Var(&interval, "i", 1, "seconds between connection attempts")
+	fs.Float64Var(&timeout, "W", 3, "seconds to wait for each connection")
+	fs.BoolVar(&ipv4, "4", false, "use IPv4 only")
+	fs.BoolVar(&ipv6, "6", false, "use IPv6 only")
+
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return nil, err
+	}
+	if len(positional) != 1 {
+		return nil, errors.New("The arguments must contain exactly one host:port.")
+	}
+	if opts.host, opts.port, err = net.SplitHostPort(positional[0]); err != nil || opts.host == "" || opts.port == "" {
+		return nil, fmt.Errorf("The destination must be in the host:port format: '%s'", positional[0])
+	}
+	if _, err := net.LookupPort("tcp", opts.port); err != nil {
+		return nil, fmt.Errorf("The port is invalid: '%s'", opts.port)
+	}
+
+	if opts.network, err = ipNetwork(ipv4, ipv6); err != nil {
+		return nil, err
+	}
+	if opts.count < 1 || opts.count > 100 {
+		return nil, fmt.Errorf("The count must be between 1 and 100: %d", opts.count)
+	}
+	if interval < 0.01 || interval > 10 {
+		return nil, fmt.Errorf("The interval must be between 0.01 and 10 seconds: %g", interval)
+	}
+	if timeout < 0.1 || timeout > 30 {
+		return nil, fmt.Errorf("The timeout must be between 0.1 and 30 seconds: %g", timeout)
+	}
+	opts.interval = seconds(interval)
+	opts.timeout = seconds(timeout)
+
+	return opts, nil
+}
+
+type tcpProbe struct{}
+
+func (p *tcpProbe) Validate(args string) error {
+	opts, err := parseTCPArguments(args)
+	if err != nil {
+		return err
+	}
+	return targetPolicy.CheckHost(opts.host)
+}
+
+func (p *tcpProbe) Run(ctx context.Context, args string) (Result, error) {
+	opts, err := parseTCPArguments(args)
+	if err != nil {
+		return nil, err
+	}
+
+	// Resolve once so that every attempt targets the same address and the
+	// DNS lookup is not part of the connect latency.
+	ip, err := resolveIP(ctx, opts.network, opts.host)
+	if err != nil {
+		return nil, fmt.Errorf("resolveIP -> %w", err)
+	}
+	address := net.JoinHostPort(ip.String(), opts.port)
+
+	result := &TCPResult{
+		Target:  opts.host,
+		Address: ip.String(),
+		Port:    opts.port,
+		Samples: make([]TCPAttempt, 0, opts.count),
+	}
+	rtts := make([]time.Duration, 0, opts.count)
+
+	dialer := &net.Dialer{Timeout: opts.timeout, Control: checkDialAddress}
+	for seq := 0; seq < opts.count; seq++ {
+		attempt := TCPAttempt{Sequence: seq}
+
+		start := time.Now()
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		elapsed := time.Since(start)
+		result.Attempts++
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			attempt.ErrorClass = classifyNetworkError(err)
+			attempt.Error = err.Error()
+		} else {
+			attempt.Connected = true
+			attempt.ConnectTime = milliseconds(elapsed)
+			attempt.RemoteAddress = conn.RemoteAddr().String()
+			attempt.LocalAddress = conn.LocalAddr().String()
+			conn.Close()
+
+			rtts = append(rtts, elapsed)
+			result.Connected++
+		}
+		result.Samples = append(result.Samples, attempt)
+
+		if seq < opts.count-1 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(time.Until(start.Add(opts.interval))):
+			}
+		}
+	}
+
+	result.Loss = 100 * float64(result.Attempts-result.Connected) / float64(result.Attempts)
+	result.RTT = newRTTStatistics(rtts)
+
+	return result, nil
+}
+
+func init() {
+	Register("tcp", &tcpProbe{})
+}
@@ -0,0 +1,264 @@
+package probes
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+var tlsVersions = map[uint16]string{
+	tls.VersionTLS10: "TLS 1.0",
+	tls.VersionTLS11: "TLS 1.1",
+	tls.VersionTLS12: "TLS 1.2",
+	tls.VersionTLS13: "TLS 1.3",
+}
+
+// TLSCertificate describes one certificate of the peer chain.
+type TLSCertificate struct {
+	Subject       string    `json:"subject"`
+	Issuer        string    `json:"issuer"`
+	SerialNumber  string    `json:"serial_number"`
+	SANs          []string  `json:"sans"`
+	NotBefore     time.Time `json:"not_before"`
+	NotAfter      time.Time `json:"not_after"`
+	Fingerprint   string    `json:"sha256_fingerprint"`
+	ExpiresInDays float64   `json:"expires_in_days"`
+	ExpiringSoon  bool      `json:"expiring_soon"`
+}
+
+// TLSResult holds the negotiated parameters and the peer chain of a TLS
+// handshake.
+type TLSResult struct {
+	Target            string           `json:"target"`
+	Address           string           `json:"address"`
+	ServerName        string           `json:"server_name"`
+	Version           string           `json:"version"`
+	CipherSuite       string           `json:"cipher_suite"`
+	ALPN              string           `json:"alpn,omitempty"`
+	OCSPStapled       bool             `json:"ocsp_stapled"`
+	Verified          bool             `json:"verified"`
+	VerificationError string           `json:"verification_error,omitempty"`
+	ConnectTime       float64          `json:"connect_ms"`
+	HandshakeTime     float64          `json:"handshake_ms"`
+	ExpiryWindowDays  int              `json:"expiry_window_days"`
+	ExpiringSoon      bool             `json:"expiring_soon"`
+	Certificates      []TLSCertificate `json:"certificates"`
+}
+
+func (r *TLSResult) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s (%s) SNI=%s: %s %s", r.Target, r.Address, r.ServerName, r.Version, r.CipherSuite)
+	if r.ALPN != "" {
+		fmt.Fprintf(&b, " ALPN=%s", r.ALPN)
+	}
+	fmt.Fprintf(&b, " OCSP stapled=%t verified=%t handshake=%.3f ms\n", r.OCSPStapled, r.Verified, r.HandshakeTime)
+	for i, c := range r.Certificates {
+		fmt.Fprintf(&b, "%d s:%s\n  i:%s\n  not after: %s (%.1f days)", i, c.Subject, c.Issuer, c.NotAfter.Format(time.RFC3339), c.ExpiresInDays)
+		if c.ExpiringSoon {
+			b.WriteString(" EXPIRING SOON")
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// Latency is the time the TLS handshake took.
+func (r *TLSResult) Latency() (float64, bool) {
+	return r.HandshakeTime, true
+}
+
+type tlsOptions struct {
+	serverName   string
+	alpn         []string
+	expiryWindow int
+	timeout      time.Duration
+	network      string
+	address      string
+	host         string
+}
+
+// parseTLSArguments accepts [-4|-6] [-sni name] [-alpn protocols]
+// [-expiry days] [-W timeout] host[:port]. The port defaults to 443 and
+// the SNI to the host name.
+func parseTLSArguments(args string) (*tlsOptions, error) {
+	opts := &tlsOptions{}
+
+	var alpn string
+	var timeout float64
+	var ipv4, ipv6 bool
+	fs := newFlagSet("tls")
+	fs.StringVar(&opts.serverName, "sni", "", "server name indication, defaults to the host")
+	fs.StringVar(&alpn, "alpn", "", "a comma delimited list of ALPN protocols")
+	fs.IntVar(&opts.expiryWindow, "expiry", 30, "days before expiry from which a certificate is flagged")
+	fs.Float64Var(&timeout, "W", 10, "seconds to wait for the handshake")
+	fs.BoolVar(&ipv4, "4", false, "use IPv4 only")
+	fs.BoolVar(&ipv6, "6", false, "use IPv6 only")
+
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return nil, err
+	}
+	if len(positional) != 1 {
+		return nil, errors.New("The arguments must contain exactly one host[:port].")
+	}
+
+	opts.address = withDefaultPort(positional[0], "443")
+	host, _, err := net.SplitHostPort(opts.address)
+	if err != nil || host == "" {
+		return nil, fmt.Errorf("The destination is invalid: '%s'", positional[0])
+	}
+	opts.host = host
+	if opts.serverName == "" && net.ParseIP(host) == nil {
+		opts.serverName = host
+	}
+
+	for _, proto := range strings.Split(alpn, ",") {
+		if proto = strings.TrimSpace(proto); proto != "" {
+			opts.alpn = append(opts.alpn, proto)
+		}
+	}
+
+	if opts.network, err = ipNetwork(ipv4, ipv6); err != nil {
+		return nil, err
+	}
+	opts.network = strings.Replace(opts.network, "ip", "tcp", 1)
+
+	if opts.expiryWindow < 0 || opts.expiryWindow > 3650 {
+		return nil, fmt.Errorf("The expiry window must be between 0 and 3650 days: %d", opts.expiryWindow)
+	}
+	if timeout < 0.1 || timeout > 60 {
+		return nil, fmt.Errorf("The timeout must be between 0.1 and 60 seconds: %g", timeout)
+	}
+	opts.timeout = seconds(timeout)
+
+	return opts, nil
+}
+
+// verifyChain validates the peer chain against the system roots.
+func verifyChain(certs []*x509.Certificate, serverName string) error {
+	if len(certs) == 0 {
+		return errors.New("The peer did not present any certificate.")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, c := range certs[1:] {
+		intermediates.AddCert(c)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		DNSName:       serverName,
+		Intermediates: intermediates,
+	})
+	return err
+}
+
+// subjectAlternativeNames lists every SAN of a certificate.
+func subjectAlternativeNames(c *x509.Certificate) []string {
+	sans := make([]string, 0, len(c.DNSNames)+len(c.IPAddresses))
+	sans = append(sans, c.DNSNames...)
+	for _, ip := range c.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	sans = append(sans, c.EmailAddresses...)
+	for _, u := range c.URIs {
+		sans = append(sans, u.String())
+	}
+	return sans
+}
+
+type tlsProbe struct{}
+
+func (p *tlsProbe) Validate(args string) error {
+	opts, err := parseTLSArguments(args)
+	if err != nil {
+		return err
+	}
+	return targetPolicy.CheckHost(opts.host)
+}
+
+func (p *tlsProbe) Run(ctx context.Context, args string) (Result, error) {
+	opts, err := parseTLSArguments(args)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opts.timeout)
+	defer cancel()
+
+	start := time.Now()
+	dialer := &net.Dialer{Control: checkDialAddress}
+	rawConn, err := dialer.DialContext(ctx, opts.network, opts.address)
+	if err != nil {
+		return nil, fmt.Errorf("dialer.DialContext -> %w", err)
+	}
+	connected := time.Now()
+
+	// The chain is verified separately so that invalid chains can still be
+	// inspected.
+	conn := tls.Client(rawConn, &tls.Config{
+		ServerName:         opts.serverName,
+		NextProtos:         opts.alpn,
+		InsecureSkipVerify: true,
+	})
+	defer conn.Close()
+
+	if err := conn.HandshakeContext(ctx); err != nil {
+		return nil, fmt.Errorf("conn.HandshakeContext -> %w", err)
+	}
+	handshaken := time.Now()
+
+	state := conn.ConnectionState()
+	result := &TLSResult{
+		Target:           opts.host,
+		Address:          rawConn.RemoteAddr().String(),
+		ServerName:       opts.serverName,
+		Version:          tlsVersions[state.Version],
+		CipherSuite:      tls.CipherSuiteName(state.CipherSuite),
+		ALPN:             state.NegotiatedProtocol,
+		OCSPStapled:      len(state.OCSPResponse) > 0,
+		ConnectTime:      milliseconds(connected.Sub(start)),
+		HandshakeTime:    milliseconds(handshaken.Sub(connected)),
+		ExpiryWindowDays: opts.expiryWindow,
+		Certificates:     make([]TLSCertificate, 0, len(state.PeerCertificates)),
+	}
+	if result.Version == "" {
+		result.Version = fmt.Sprintf("0x%04x", state.Version)
+	}
+
+	if err := verifyChain(state.PeerCertificates, opts.serverName); err != nil {
+		result.VerificationError = err.Error()
+	} else {
+		result.Verified = true
+	}
+
+	window := time.Duration(opts.expiryWindow) * 24 * time.Hour
+	for _, c := range state.PeerCertificates {
+		fingerprint := sha256.Sum256(c.Raw)
+		remaining := c.NotAfter.Sub(handshaken)
+		cert := TLSCertificate{
+			Subject:       c.Subject.String(),
+			Issuer:        c.Issuer.String(),
+			SerialNumber:  c.SerialNumber.Text(16),
+			SANs:          subjectAlternativeNames(c),
+			NotBefore:     c.NotBefore,
+			NotAfter:      c.NotAfter,
+			Fingerprint:   hex.EncodeToString(fingerprint[:]),
+			ExpiresInDays: remaining.Hours() / 24,
+			ExpiringSoon:  remaining < window,
+		}
+		result.ExpiringSoon = result.ExpiringSoon || cert.ExpiringSoon
+		result.Certificates = append(result.Certificates, cert)
+	}
+
+	return result, nil
+}
+
+func init() {
+	Register("tls", &tlsProbe{})
+}
@@ -0,0 +1,738 @@
+package probes
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// TracerouteProbe is the outcome of a single probe sent with a given TTL.
+type TracerouteProbe struct {
+	Address    string  `json:"address,omitempty"`
+	RTT        float64 `json:"rtt_ms,omitempty"`
+	Timeout    bool    `json:"timeout,omitempty"`
+	Annotation string  `json:"annotation,omitempty"`
+}
+
+// TracerouteHop gathers the probes sent with the same TTL.
+type TracerouteHop struct {
+	TTL      int               `json:"ttl"`
+	Address  string            `json:"address,omitempty"`
+	RTTs     []float64         `json:"rtts_ms"`
+	Timeouts int               `json:"timeouts"`
+	Probes   []TracerouteProbe `json:"probes"`
+}
+
+// TracerouteResult holds the path towards a destination, hop by hop.
+type TracerouteResult struct {
+	Target   string          `json:"target"`
+	Address  string          `json:"address"`
+	Protocol string          `json:"protocol"`
+	Port     int             `json:"port,omitempty"`
+	FlowID   int             `json:"flow_id"`
+	MaxTTL   int             `json:"max_ttl"`
+	Queries  int             `json:"queries"`
+	Reached  bool            `json:"reached"`
+	Hops     []TracerouteHop `json:"hops"`
+}
+
+func (r *TracerouteResult) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "traceroute to %s (%s), %d hops max, %s\n", r.Target, r.Address, r.MaxTTL, r.Protocol)
+	for _, hop := range r.Hops {
+		fmt.Fprintf(&b, "%2d ", hop.TTL)
+		last := ""
+		for _, p := range hop.Probes {
+			if p.Timeout {
+				b.WriteString(" *")
+				continue
+			}
+			if p.Address != last {
+				fmt.Fprintf(&b, " %s", p.Address)
+				last = p.Address
+			}
+			fmt.Fprintf(&b, "  %.3f ms", p.RTT)
+			if p.Annotation != "" {
+				fmt.Fprintf(&b, " %s", p.Annotation)
+			}
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// Latency is the average round-trip time to the destination, when it was
+// reached.
+func (r *TracerouteResult) Latency() (float64, bool) {
+	if !r.Reached || len(r.Hops) == 0 || len(r.Hops[len(r.Hops)-1].RTTs) == 0 {
+		return 0, false
+	}
+	var sum float64
+	rtts := r.Hops[len(r.Hops)-1].RTTs
+	for _, rtt := range rtts {
+		sum += rtt
+	}
+	return sum / float64(len(rtts)), true
+}
+
+type tracerouteOptions struct {
+	protocol string
+	firstTTL int
+	maxTTL   int
+	queries  int
+	port     int
+	wait     time.Duration
+	network  string
+	host     string
+}
+
+// parseTracerouteArguments accepts a subset of the traceroute command line:
+// [-4|-6] [-M udp|icmp|tcp] [-f first_ttl] [-m max_ttl] [-q nqueries]
+// [-p port] [-w waittime] host
+func parseTracerouteArguments(args string) (*tracerouteOptions, error) {
+	opts := &tracerouteOptions{}
+
+	var wait float64
+	var ipv4, ipv6 bool
+	fs := newFlagSet("traceroute")
+	fs.StringVar(&opts.protocol, "M", "udp", "probe method, one of udp, icmp or tcp")
+	fs.IntVar(&opts.firstTTL, "f", 1, "TTL of the first probe")
+	fs.IntVar(&opts.maxTTL, "m", 30, "maximum TTL")
+	fs.IntVar(&opts.queries, "q", 3, "number of probes per hop")
+	fs.IntVar(&opts.port, "p", 0, "destination port of udp and tcp probes")
+	fs.Float64Var(&wait, "w", 2, "seconds to wait for each reply")
+	fs.BoolVar(&ipv4, "4", false, "use IPv4 only")
+	fs.BoolVar(&ipv6, "6", false, "use IPv6 only")
+
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return nil, err
+	}
+	if len(positional) != 1 {
+		return nil, errors.New("The arguments must contain exactly one destination host.")
+	}
+	opts.host = positional[0]
+
+	if opts.network, err = ipNetwork(ipv4, ipv6); err != nil {
+		return nil, err
+	}
+
+	opts.protocol = strings.ToLower(opts.protocol)
+	switch opts.protocol {
+	case "udp":
+		if opts.port == 0 {
+			opts.port = 33434
+		}
+	case "tcp":
+		if opts.port == 0 {
+			opts.port = 80
+		}
+	case "icmp":
+		opts.port = 0
+	default:
+		return nil, fmt.Errorf("The probe method must be one of [udp|icmp|tcp]: '%s'", opts.protocol)
+	}
+
+	if opts.maxTTL < 1 || opts.maxTTL > 64 {
+		return nil, fmt.Errorf("The maximum TTL must be between 1 and 64: %d", opts.maxTTL)
+	}
+	if opts.firstTTL < 1 || opts.firstTTL > opts.maxTTL {
+		return nil, fmt.Errorf("The first TTL must be between 1 and the maximum TTL: %d", opts.firstTTL)
+	}
+	if opts.queries < 1 || opts.queries > 10 {
+		return nil, fmt.Errorf("The number of probes per hop must be between 1 and 10: %d", opts.queries)
+	}
+	if opts.port < 0 || opts.port > 65535 {
+		return nil, fmt.Errorf("The port must be between 1 and 65535: %d", opts.port)
+	}
+	if wait < 0.1 || wait > 10 {
+		return nil, fmt.Errorf("The wait time must be between 0.1 and 10 seconds: %g", wait)
+	}
+	opts.wait = seconds(wait)
+
+	return opts, nil
+}
+
+// icmpReply is an ICMP message received while tracing a path.
+type icmpReply struct {
+	msg  *icmp.Message
+	from net.IP
+	at   time.Time
+}
+
+// quotedPacket extracts the destination, protocol and first eight bytes of
+// the transport header of the datagram quoted in an ICMP error.
+func quotedPacket(data []byte, v6 bool) (net.IP, int, []byte, bool) {
+	if v6 {
+		if len(data) < ipv6.HeaderLen+8 {
+			return nil, 0, nil, false
+		}
+		return net.IP(data[24:40]), int(data[6]), data[ipv6.HeaderLen : ipv6.HeaderLen+8], true
+	}
+
+	if len(data) < ipv4.HeaderLen {
+		return nil, 0, nil, false
+	}
+	hdrlen := int(data[0]&0x0f) << 2
+	if len(data) < hdrlen+8 {
+		return nil, 0, nil, false
+	}
+	return net.IP(data[16:20]), int(data[9]), data[hdrlen : hdrlen+8], true
+}
+
+// tracer sends probes of one kind and recognises the replies they trigger.
+// Paris-style, every probe of a run carries the same flow identifier, so
+// that per-flow load balancers keep all of them on the same path, while a
+// field outside of the flow identifies the probe: the sequence number of
+// ICMP echo requests and TCP segments, and the checksum of UDP datagrams.
+// A reply is only credited to the probe it quotes, so that a late reply is
+// never taken for the answer to the next probe.
+type tracer struct {
+	opts     *tracerouteOptions
+	dst      net.IP
+	v6       bool
+	listener *icmpConn
+	replies  chan icmpReply
+	done     chan struct{}
+
+	src     net.IP
+	srcPort int
+	id      int
+
+	// raw is the raw socket that UDP datagrams and TCP SYN segments are
+	// sent on, with a checksum computed by the tracer rather than left to
+	// the kernel, which may compute it after the packet has been quoted.
+	raw *net.IPConn
+	// segments are the answers of the destination to a TCP trace.
+	segments chan tcpSegment
+}
+
+// checksumNeutralPayload returns the payload of an ICMP echo request whose
+// first two bytes compensate the sequence number, so that the checksum, and
+// therefore the flow as seen by load balancers, is the same for every probe.
+func checksumNeutralPayload(seq int) []byte {
+	payload := make([]byte, 32)
+	binary.BigEndian.PutUint16(payload, uint16(0xffff-seq))
+	return payload
+}
+
+// TCP flags of the segments sent and received by TCP traces.
+const (
+	tcpSYN = 0x02
+	tcpRST = 0x04
+	tcpACK = 0x10
+)
+
+// tcpSegment is the part of a received TCP segment needed to recognise the
+// answer to a probe.
+type tcpSegment struct {
+	srcPort int
+	dstPort int
+	ack     uint32
+	flags   byte
+	at      time.Time
+}
+
+// parseTCPSegment decodes the header of a TCP segment.
+func parseTCPSegment(b []byte) (tcpSegment, bool) {
+	if len(b) < 20 {
+		return tcpSegment{}, false
+	}
+	return tcpSegment{
+		srcPort: int(binary.BigEndian.Uint16(b[0:2])),
+		dstPort: int(binary.BigEndian.Uint16(b[2:4])),
+		ack:     binary.BigEndian.Uint32(b[8:12]),
+		flags:   b[13],
+	}, true
+}
+
+// tcpProbeSegment builds a SYN segment with the given sequence number and
+// its checksum, which raw sockets leave to the sender.
+func tcpProbeSegment(src, dst net.IP, srcPort, dstPort int, seq uint32) []byte {
+	b := make([]byte, 20)
+	binary.BigEndian.PutUint16(b[0:2], uint16(srcPort))
+	binary.BigEndian.PutUint16(b[2:4], uint16(dstPort))
+	binary.BigEndian.PutUint32(b[4:8], seq)
+	b[12] = 5 << 4
+	b[13] = tcpSYN
+	binary.BigEndian.PutUint16(b[14:16], 0xffff)
+
+	sum := onesComplementSum(pseudoHeaderSum(src, dst, syscall.IPPROTO_TCP, len(b)), b)
+	binary.BigEndian.PutUint16(b[16:18], ^foldChecksum(sum))
+	return b
+}
+
+// udpProbeDatagram builds a UDP datagram whose checksum is id, by choosing
+// the first two bytes of its payload. The checksum is part of the eight
+// bytes of the header quoted in ICMP errors, but not of the flow as seen by
+// load balancers.
+func udpProbeDatagram(src, dst net.IP, srcPort, dstPort int, id uint16) []byte {
+	b := make([]byte, 8+32)
+	binary.BigEndian.PutUint16(b[0:2], uint16(srcPort))
+	binary.BigEndian.PutUint16(b[2:4], uint16(dstPort))
+	binary.BigEndian.PutUint16(b[4:6], uint16(len(b)))
+
+	// The checksum is the complement of the sum of the datagram, which
+	// must therefore add up to the complement of id.
+	sum := onesComplementSum(pseudoHeaderSum(src, dst, syscall.IPPROTO_UDP, len(b)), b)
+	binary.BigEndian.PutUint16(b[8:10], foldChecksum(uint32(^id)+uint32(^foldChecksum(sum))))
+	binary.BigEndian.PutUint16(b[6:8], id)
+	return b
+}
+
+// pseudoHeaderSum returns the sum of the pseudo-header covered by the
+// checksum of TCP and UDP.
+func pseudoHeaderSum(src, dst net.IP, proto, length int) uint32 {
+	if src4, dst4 := src.To4(), dst.To4(); src4 != nil && dst4 != nil {
+		return onesComplementSum(onesComplementSum(0, src4), dst4) + uint32(proto) + uint32(length)
+	}
+	sum := onesComplementSum(onesComplementSum(0, src.To16()), dst.To16())
+	return sum + uint32(length>>16) + uint32(length&0xffff) + uint32(proto)
+}
+
+// onesComplementSum adds b, as big-endian 16-bit words, to sum.
+func onesComplementSum(sum uint32, b []byte) uint32 {
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	return sum
+}
+
+// foldChecksum folds the carries of sum into 16 bits.
+func foldChecksum(sum uint32) uint16 {
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return uint16(sum)
+}
+
+// sendICMP emits an echo request with the given TTL and sequence number.
+func (t *tracer) sendICMP(ttl, seq int) error {
+	if err := t.listener.setTTL(ttl); err != nil {
+		return fmt.Errorf("listener.setTTL -> %w", err)
+	}
+
+	var typ icmp.Type = ipv4.ICMPTypeEcho
+	if t.v6 {
+		typ = ipv6.ICMPTypeEchoRequest
+	}
+	msg := icmp.Message{
+		Type: typ,
+		Body: &icmp.Echo{ID: t.id, Seq: seq, Data: checksumNeutralPayload(seq)},
+	}
+	wb, err := msg.Marshal(nil)
+	if err != nil {
+		return fmt.Errorf("msg.Marshal -> %w", err)
+	}
+	if _, err := t.listener.WriteTo(wb, &net.IPAddr{IP: t.dst}); err != nil {
+		return fmt.Errorf("listener.WriteTo -> %w", err)
+	}
+	return nil
+}
+
+// sendUDP emits a datagram with the given TTL on the constant UDP flow,
+// with the sequence number as its checksum.
+func (t *tracer) sendUDP(ttl, seq int) error {
+	return t.sendRaw(ttl, udpProbeDatagram(t.src, t.dst, t.srcPort, t.opts.port, uint16(seq)))
+}
+
+// sendTCP emits a SYN segment with the given TTL on the constant TCP flow,
+// with a sequence number identifying the probe.
+func (t *tracer) sendTCP(ttl, seq int) error {
+	return t.sendRaw(ttl, tcpProbeSegment(t.src, t.dst, t.srcPort, t.opts.port, t.tcpSequence(seq)))
+}
+
+// sendRaw emits a transport packet with the given TTL on the raw socket.
+func (t *tracer) sendRaw(ttl int, packet []byte) error {
+	var err error
+	if t.v6 {
+		err = ipv6.NewPacketConn(t.raw).SetHopLimit(ttl)
+	} else {
+		err = ipv4.NewPacketConn(t.raw).SetTTL(ttl)
+	}
+	if err != nil {
+		return fmt.Errorf("SetTTL -> %w", err)
+	}
+	if _, err := t.raw.WriteTo(packet, &net.IPAddr{IP: t.dst}); err != nil {
+		return fmt.Errorf("raw.WriteTo -> %w", err)
+	}
+	return nil
+}
+
+// tcpSequence is the sequence number of the SYN segment of a probe. It
+// includes the identifier of the trace, since concurrent traces may pick
+// the same source port.
+func (t *tracer) tcpSequence(seq int) uint32 {
+	return uint32(t.id)<<16 | uint32(seq)
+}
+
+// match reports whether reply answers the probe with the given sequence
+// number, whether it comes from the destination, and its annotation.
+func (t *tracer) match(reply icmpReply, seq int) (bool, bool, string) {
+	switch body := reply.msg.Body.(type) {
+	case *icmp.Echo:
+		if t.opts.protocol == "icmp" && body.ID == t.id && body.Seq == seq && reply.from.Equal(t.dst) {
+			return true, true, ""
+		}
+
+	case *icmp.TimeExceeded:
+		if t.quotes(body.Data, seq) {
+			return true, false, ""
+		}
+
+	case *icmp.DstUnreach:
+		if t.quotes(body.Data, seq) {
+			return true, true, unreachableAnnotation(reply.msg, t.v6)
+		}
+	}
+	return false, false, ""
+}
+
+// quotes reports whether an ICMP error quotes one of our probes.
+func (t *tracer) quotes(data []byte, seq int) bool {
+	dst, proto, header, ok := quotedPacket(data, t.v6)
+	if !ok || !dst.Equal(t.dst) {
+		return false
+	}
+
+	switch t.opts.protocol {
+	case "icmp":
+		if proto != protocolICMP && proto != protocolIPv6ICMP {
+			return false
+		}
+		return int(binary.BigEndian.Uint16(header[4:6])) == t.id &&
+			int(binary.BigEndian.Uint16(header[6:8])) == seq
+	case "udp":
+		if proto != syscall.IPPROTO_UDP {
+			return false
+		}
+		return t.quotesFlow(header) && int(binary.BigEndian.Uint16(header[6:8])) == seq
+	case "tcp":
+		if proto != syscall.IPPROTO_TCP {
+			return false
+		}
+		return t.quotesFlow(header) && binary.BigEndian.Uint32(header[4:8]) == t.tcpSequence(seq)
+	}
+	return false
+}
+
+// quotesFlow reports whether a quoted UDP or TCP header belongs to the flow
+// of the trace.
+func (t *tracer) quotesFlow(header []byte) bool {
+	return int(binary.BigEndian.Uint16(header[0:2])) == t.srcPort &&
+		int(binary.BigEndian.Uint16(header[2:4])) == t.opts.port
+}
+
+// unreachableAnnotation renders a destination unreachable code the way
+// traceroute does. Port unreachable is the expected answer of a UDP trace
+// and carries no annotation.
+func unreachableAnnotation(msg *icmp.Message, v6 bool) string {
+	if v6 {
+		switch msg.Code {
+		case 4:
+			return ""
+		case 1:
+			return "!X"
+		case 3:
+			return "!H"
+		default:
+			return fmt.Sprintf("!<%d>", msg.Code)
+		}
+	}
+
+	switch msg.Code {
+	case 3:
+		return ""
+	case 0:
+		return "!N"
+	case 1:
+		return "!H"
+	case 2:
+		return "!P"
+	case 9, 10, 13:
+		return "!X"
+	default:
+		return fmt.Sprintf("!<%d>", msg.Code)
+	}
+}
+
+// receive forwards every ICMP message read by the listener until it is
+// closed.
+func (t *tracer) receive() {
+	defer close(t.replies)
+
+	buf := make([]byte, 1500)
+	for {
+		msg, _, from, err := t.listener.read(buf)
+		at := time.Now()
+		if err != nil {
+			// Only failures of the socket itself are fatal; a malformed
+			// message is skipped.
+			var opErr *net.OpError
+			if errors.As(err, &opErr) {
+				return
+			}
+			continue
+		}
+
+		select {
+		case t.replies <- icmpReply{msg: msg, from: from, at: at}:
+		case <-t.done:
+			return
+		}
+	}
+}
+
+// receiveTCP forwards the answers of the destination to the SYN segments,
+// a SYN-ACK or a RST, until the raw socket is closed. The kernel resets
+// the connections, since no socket is bound to the source port.
+func (t *tracer) receiveTCP() {
+	defer close(t.segments)
+
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := t.raw.ReadFrom(buf)
+		at := time.Now()
+		if err != nil {
+			return
+		}
+
+		segment, ok := parseTCPSegment(buf[:n])
+		if !ok || !from.(*net.IPAddr).IP.Equal(t.dst) ||
+			segment.srcPort != t.opts.port || segment.dstPort != t.srcPort {
+			continue
+		}
+		if segment.flags&tcpRST == 0 && segment.flags&(tcpSYN|tcpACK) != tcpSYN|tcpACK {
+			continue
+		}
+		segment.at = at
+
+		select {
+		case t.segments <- segment:
+		case <-t.done:
+			return
+		}
+	}
+}
+
+// probe sends one probe and waits for its answer.
+func (t *tracer) probe(ctx context.Context, ttl, seq int) (TracerouteProbe, bool, error) {
+	probeCtx, cancel := context.WithTimeout(ctx, t.opts.wait)
+	defer cancel()
+
+	sent := time.Now()
+	var err error
+	switch t.opts.protocol {
+	case "icmp":
+		err = t.sendICMP(ttl, seq)
+	case "udp":
+		err = t.sendUDP(ttl, seq)
+	case "tcp":
+		err = t.sendTCP(ttl, seq)
+	}
+	if err != nil {
+		return TracerouteProbe{}, false, err
+	}
+
+	for {
+		select {
+		case <-probeCtx.Done():
+			if ctx.Err() != nil {
+				return TracerouteProbe{}, false, ctx.Err()
+			}
+			return TracerouteProbe{Timeout: true}, false, nil
+
+		case segment, ok := <-t.segments:
+			if !ok {
+				return TracerouteProbe{}, false, errors.New("The TCP listener has been closed.")
+			}
+			// Both a SYN-ACK and a RST acknowledge the sequence number
+			// of the SYN they answer.
+			if segment.ack != t.tcpSequence(seq)+1 {
+				continue
+			}
+			return TracerouteProbe{
+				Address: t.dst.String(),
+				RTT:     milliseconds(segment.at.Sub(sent)),
+			}, true, nil
+
+		case reply, ok := <-t.replies:
+			if !ok {
+				return TracerouteProbe{}, false, errors.New("The ICMP listener has been closed.")
+			}
+			matched, reached, annotation := t.match(reply, seq)
+			if !matched {
+				continue
+			}
+			return TracerouteProbe{
+				Address:    reply.from.String(),
+				RTT:        milliseconds(reply.at.Sub(sent)),
+				Annotation: annotation,
+			}, reached, nil
+		}
+	}
+}
+
+type tracerouteProbe struct{}
+
+func (p *tracerouteProbe) Validate(args string) error {
+	opts, err := parseTracerouteArguments(args)
+	if err != nil {
+		return err
+	}
+	return targetPolicy.CheckHost(opts.host)
+}
+
+// Check opens and closes every raw socket the modes of the probe need: the
+// ICMP socket the replies are only delivered to, and the UDP and TCP sockets
+// the probes of those modes are sent and answered on.
+func (p *tracerouteProbe) Check() error {
+	conn, err := icmp.ListenPacket("ip4:icmp", "0.0.0.0")
+	if err != nil {
+		return fmt.Errorf("icmp.ListenPacket -> %w", err)
+	}
+	conn.Close()
+
+	for _, protocol := range []string{"udp", "tcp"} {
+		raw, err := net.ListenIP("ip4:"+protocol, nil)
+		if err != nil {
+			return fmt.Errorf("net.ListenIP -> %w", err)
+		}
+		raw.Close()
+	}
+	return nil
+}
+
+func (p *tracerouteProbe) Run(ctx context.Context, args string) (Result, error) {
+	opts, err := parseTracerouteArguments(args)
+	if err != nil {
+		return nil, err
+	}
+
+	dst, err := resolveIP(ctx, opts.network, opts.host)
+	if err != nil {
+		return nil, fmt.Errorf("resolveIP -> %w", err)
+	}
+	v6 := dst.To4() == nil
+
+	t := &tracer{
+		opts:    opts,
+		dst:     dst,
+		v6:      v6,
+		replies: make(chan icmpReply, 64),
+		done:    make(chan struct{}),
+		id:      nextEchoID(),
+	}
+
+	// ICMP errors are only delivered to raw sockets.
+	network, address := "ip4:icmp", "0.0.0.0"
+	if v6 {
+		network, address = "ip6:ipv6-icmp", "::"
+	}
+	conn, err := icmp.ListenPacket(network, address)
+	if err != nil {
+		return nil, fmt.Errorf("icmp.ListenPacket -> %w", err)
+	}
+	t.listener = &icmpConn{PacketConn: conn, privileged: true}
+	if v6 {
+		t.listener.v6 = conn.IPv6PacketConn()
+	} else {
+		t.listener.v4 = conn.IPv4PacketConn()
+	}
+	defer conn.Close()
+	defer close(t.done)
+	go t.receive()
+
+	if opts.protocol != "icmp" {
+		if t.src, err = sourceAddress(dst); err != nil {
+			return nil, fmt.Errorf("sourceAddress -> %w", err)
+		}
+		network := "ip4:"
+		if v6 {
+			network = "ip6:"
+		}
+		raw, err := net.ListenIP(network+opts.protocol, nil)
+		if err != nil {
+			return nil, fmt.Errorf("net.ListenIP -> %w", err)
+		}
+		defer raw.Close()
+		t.raw = raw
+	}
+
+	switch opts.protocol {
+	case "udp":
+		// The socket is never read; it keeps the source port from being
+		// used by another trace.
+		udp, err := net.ListenUDP("udp", &net.UDPAddr{IP: t.src})
+		if err != nil {
+			return nil, fmt.Errorf("net.ListenUDP -> %w", err)
+		}
+		defer udp.Close()
+		t.srcPort = udp.LocalAddr().(*net.UDPAddr).Port
+	case "tcp":
+		t.srcPort = 33000 + randomIntn(28000)
+		t.segments = make(chan tcpSegment, 16)
+		go t.receiveTCP()
+	}
+
+	result := &TracerouteResult{
+		Target:   opts.host,
+		Address:  dst.String(),
+		Protocol: opts.protocol,
+		Port:     opts.port,
+		FlowID:   t.srcPort,
+		MaxTTL:   opts.maxTTL,
+		Queries:  opts.queries,
+		Hops:     make([]TracerouteHop, 0, opts.maxTTL),
+	}
+	if opts.protocol == "icmp" {
+		result.FlowID = t.id
+	}
+
+	seq := 0
+	for ttl := opts.firstTTL; ttl <= opts.maxTTL && !result.Reached; ttl++ {
+		hop := TracerouteHop{
+			TTL:    ttl,
+			RTTs:   make([]float64, 0, opts.queries),
+			Probes: make([]TracerouteProbe, 0, opts.queries),
+		}
+		for q := 0; q < opts.queries; q++ {
+			seq++
+			probe, reached, err := t.probe(ctx, ttl, seq)
+			if err != nil {
+				return nil, fmt.Errorf("t.probe -> %w", err)
+			}
+			hop.Probes = append(hop.Probes, probe)
+			if probe.Timeout {
+				hop.Timeouts++
+				continue
+			}
+			if hop.Address == "" {
+				hop.Address = probe.Address
+			}
+			hop.RTTs = append(hop.RTTs, probe.RTT)
+			if reached {
+				result.Reached = true
+			}
+		}
+		result.Hops = append(result.Hops, hop)
+	}
+
+	return result, nil
+}
+
+func init() {
+	Register("traceroute", &tracerouteProbe{})
+}
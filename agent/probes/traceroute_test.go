@@ -0,0 +1,57 @@
+package probes
+
+import (
+	"encoding/binary"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUDPProbeDatagramChecksum(t *testing.T) {
+	for _, addrs := range [][2]string{{"192.0.2.1", "198.51.100.7"}, {"2001:db8::1", "2001:db8:ffff::7"}} {
+		src, dst := net.ParseIP(addrs[0]), net.ParseIP(addrs[1])
+		for _, id := range []uint16{1, 2, 640} {
+			datagram := udpProbeDatagram(src, dst, 40000, 33434, id)
+
+			sum := onesComplementSum(pseudoHeaderSum(src, dst, syscall.IPPROTO_UDP, len(datagram)), datagram)
+			assert.Equal(t, uint16(0xffff), foldChecksum(sum), "The checksum of the datagram must be valid: %s, %d", dst, id)
+			assert.Equal(t, id, binary.BigEndian.Uint16(datagram[6:8]), "The checksum must be the probe identifier: %s, %d", dst, id)
+		}
+	}
+}
+
+func TestTCPProbeSegmentChecksum(t *testing.T) {
+	src, dst := net.ParseIP("192.0.2.1"), net.ParseIP("198.51.100.7")
+	segment := tcpProbeSegment(src, dst, 40000, 443, 0x12340005)
+
+	sum := onesComplementSum(pseudoHeaderSum(src, dst, syscall.IPPROTO_TCP, len(segment)), segment)
+	assert.Equal(t, uint16(0xffff), foldChecksum(sum), "The checksum of the segment must be valid.")
+	assert.Equal(t, uint32(0x12340005), binary.BigEndian.Uint32(segment[4:8]), "The sequence number must identify the probe.")
+}
+
+func TestTracerQuotesOnlyItsProbe(t *testing.T) {
+	dst := net.ParseIP("198.51.100.7")
+	tr := &tracer{
+		opts:    &tracerouteOptions{protocol: "udp", port: 33434},
+		dst:     dst,
+		srcPort: 40000,
+	}
+
+	// An IPv4 header followed by the first eight bytes of the UDP probe
+	// with sequence number 3.
+	quoted := make([]byte, 28)
+	quoted[0] = 0x45
+	quoted[9] = syscall.IPPROTO_UDP
+	copy(quoted[16:20], dst.To4())
+	binary.BigEndian.PutUint16(quoted[20:22], 40000)
+	binary.BigEndian.PutUint16(quoted[22:24], 33434)
+	binary.BigEndian.PutUint16(quoted[26:28], 3)
+
+	assert.True(t, tr.quotes(quoted, 3), "The reply must be credited to the probe it quotes.")
+	assert.False(t, tr.quotes(quoted, 4), "A late reply must not be credited to the next probe.")
+
+	binary.BigEndian.PutUint16(quoted[20:22], 40001)
+	assert.False(t, tr.quotes(quoted, 3), "A reply to another flow must be ignored.")
+}
@@ -1,86 +1,94 @@
 package tasks
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"io/ioutil"
-	"net/http"
-	"time"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/miekg/dns"
+
+	"github.com/rafikurnia/measurement-measurer/utils/geoip"
 )
 
-type nodeInfo struct {
-	Status        string  `json:"status"`
-	Message       string  `json:"message,omitempty"`
-	Continent     string  `json:"continent"`
-	ContinentCode string  `json:"continentCode"`
-	Country       string  `json:"country"`
-	CountryCode   string  `json:"countryCode"`
-	Region        string  `json:"region"`
-	RegionName    string  `json:"regionName"`
-	City          string  `json:"city"`
-	District      string  `json:"district"`
-	Zip           string  `json:"zip"`
-	Lat           float64 `json:"lat"`
-	Lon           float64 `json:"lon"`
-	Timezone      string  `json:"timezone"`
-	Offset        int     `json:"offset"`
-	Currency      string  `json:"currency"`
-	ISP           string  `json:"isp"`
-	Org           string  `json:"org"`
-	AS            string  `json:"as"`
-	ASName        string  `json:"asname"`
-	Reverse       string  `json:"reverse"`
-	Mobile        bool    `json:"mobile"`
-	Proxy         bool    `json:"proxy"`
-	Hosting       bool    `json:"hosting"`
-	Query         string  `json:"query"`
+// NodeInfo describes where the measurements of this instance are taken
+// from.
+type NodeInfo struct {
+	IP string
+	// Source tells how the egress IP was found, either "env" or "dns".
+	Source     string
+	geoip.Info `structs:",flatten"`
 }
 
-func GetNodeInfo() (*nodeInfo, error) {
-	client := http.Client{
-		Timeout: 200 * time.Millisecond,
-	}
-	req, err := client.Get(
-		"http://ip-api.com/json?fields=" +
-			"status," +
-			"message," +
-			"continent," +
-			"continentCode," +
-			"country," +
-			"countryCode," +
-			"region," +
-			"regionName," +
-			"city," +
-			"district," +
-			"zip," +
-			"lat," +
-			"lon," +
-			"timezone," +
-			"offset," +
-			"currency," +
-			"isp," +
-			"org," +
-			"as," +
-			"asname," +
-			"reverse," +
-			"mobile," +
-			"proxy," +
-			"hosting," +
-			"query",
-	)
+var (
+	nodeInfo   *NodeInfo
+	nodeInfoMu sync.RWMutex
+)
 
+// InitNodeInfo resolves the egress IP of the instance and looks it up in
+// db, which may be nil. It is meant to be called once at startup, so that
+// no external service is queried during a measurement.
+func InitNodeInfo(ctx context.Context, db *geoip.DB) error {
+	ip, source, err := egressIP(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("http.Get -> %w", err)
+		return fmt.Errorf("egressIP -> %w", err)
 	}
-	defer req.Body.Close()
 
-	body, err := ioutil.ReadAll(req.Body)
-	if err != nil {
-		return nil, fmt.Errorf("ioutil.ReadAll -> %w", err)
+	n := &NodeInfo{IP: ip.String(), Source: source}
+	if db != nil {
+		info, err := db.Lookup(ip)
+		if err != nil {
+			return fmt.Errorf("db.Lookup -> %w", err)
+		}
+		n.Info = *info
 	}
 
-	nodeInfo := &nodeInfo{}
-	json.Unmarshal(body, nodeInfo)
+	nodeInfoMu.Lock()
+	nodeInfo = n
+	nodeInfoMu.Unlock()
+	return nil
+}
+
+// GetNodeInfo returns the information gathered by InitNodeInfo, or nil.
+func GetNodeInfo() *NodeInfo {
+	nodeInfoMu.RLock()
+	defer nodeInfoMu.RUnlock()
+	return nodeInfo
+}
 
-	return nodeInfo, nil
+// egressIP returns the address the instance is seen from, taken from the
+// EGRESS_IP environment variable when set, or else asked to the Google
+// authoritative name servers, which answer with the address of the client.
+func egressIP(ctx context.Context) (net.IP, string, error) {
+	if v := os.Getenv("EGRESS_IP"); v != "" {
+		ip := net.ParseIP(v)
+		if ip == nil {
+			return nil, "", fmt.Errorf("The EGRESS_IP is not a valid IP address: '%s'", v)
+		}
+		return ip, "env", nil
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion("o-o.myaddr.l.google.com.", dns.TypeTXT)
+
+	client := &dns.Client{Net: "udp"}
+	r, _, err := client.ExchangeContext(ctx, m, "ns1.google.com:53")
+	if err != nil {
+		return nil, "", fmt.Errorf("client.ExchangeContext -> %w", err)
+	}
+
+	for _, rr := range r.Answer {
+		txt, ok := rr.(*dns.TXT)
+		if !ok {
+			continue
+		}
+		for _, s := range txt.Txt {
+			if ip := net.ParseIP(s); ip != nil {
+				return ip, "dns", nil
+			}
+		}
+	}
+	return nil, "", errors.New("The DNS response does not contain any IP address")
 }
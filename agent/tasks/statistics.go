@@ -0,0 +1,47 @@
+package tasks
+
+import (
+	"github.com/rafikurnia/measurement-measurer/probes"
+)
+
+// SampleStatistics aggregates the samples of a single measurement.
+type SampleStatistics struct {
+	Samples   int
+	Successes int
+	Failures  int
+	Timeouts  int
+	// Latency summarises the headline latency of the successful samples,
+	// in milliseconds, when the probe has one.
+	Latency *probes.RTTStatistics
+}
+
+func NewSampleStatistics(samples []*Sample, latency *probes.RTTStatistics) *SampleStatistics {
+	s := &SampleStatistics{
+		Samples: len(samples),
+		Latency: latency,
+	}
+	for _, sample := range samples {
+		switch sample.Outcome {
+		case OutcomeSuccess:
+			s.Successes++
+		case OutcomeTimeout:
+			s.Timeouts++
+		default:
+			s.Failures++
+		}
+	}
+	return s
+}
+
+// Outcome is successful as soon as one sample is, a timeout when a sample
+// timed out, and a failure otherwise.
+func (s *SampleStatistics) Outcome() string {
+	switch {
+	case s.Successes > 0:
+		return OutcomeSuccess
+	case s.Timeouts > 0:
+		return OutcomeTimeout
+	default:
+		return OutcomeFailure
+	}
+}
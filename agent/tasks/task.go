@@ -6,26 +6,61 @@ import (
 	"time"
 )
 
+// The outcomes of a measurement.
+const (
+	OutcomeSuccess = "success"
+	OutcomeTimeout = "timeout"
+	OutcomeFailure = "failure"
+)
+
+// MeasurementError describes why a measurement did not produce a result.
+type MeasurementError struct {
+	// Class is a coarse category such as "timeout", "dns" or "exit".
+	Class   string
+	Message string
+	// ExitCode is the exit status of an external command, or -1.
+	ExitCode int
+	// Elapsed is the time, in milliseconds, until the probe gave up.
+	Elapsed float64
+}
+
+// Measurement is the outcome of measuring a single target.
+type Measurement struct {
+	Outcome string
+	Error   *MeasurementError
+	Result  map[string]interface{}
+	// Samples and Statistics are only set when the task asks for more than
+	// one sample per measurement.
+	Samples    []*Sample
+	Statistics *SampleStatistics
+}
+
 type Task struct {
+	MeasurerInfo         *NodeInfo
 	MeasurementStartTime time.Time
 	MeasurementStopTime  time.Time
 	Region               string
-	Result               string
-	Sequence             int
+	// ExecutionKey identifies the request the measurement was taken for.
+	ExecutionKey string
+	// Measurement is set when the target is part of the arguments, and
+	// Targets, keyed by target, when the task has a list of targets.
+	Measurement `structs:",flatten"`
+	Targets     map[string]*Measurement
+	Sequence    int
 }
 
-func NewTask() (*Task, error) {
-	// n, err := GetNodeInfo()
-	// if err != nil {
-	// 	err = fmt.Errorf("GetNodeInfo -> %w", err)
-	// }
-
-	// if n == nil {
-	// 	n = &nodeInfo{}
-	// }
+// Sample is a single run of the probe within a measurement.
+type Sample struct {
+	StartTime time.Time
+	StopTime  time.Time
+	Outcome   string
+	Error     *MeasurementError
+	Result    map[string]interface{}
+}
 
+func NewTask() (*Task, error) {
 	return &Task{
-		// MeasurerInfo:         n,
+		MeasurerInfo:         GetNodeInfo(),
 		MeasurementStartTime: time.Time{},
 		MeasurementStopTime:  time.Time{},
 		Region:               os.Getenv("REGION"),
@@ -40,6 +75,26 @@ type TaskMetadata struct {
 	Type             string
 	Status           string
 	NumberOfSequence map[string]int
+	// Timeout is the number of seconds a single measurement, including all
+	// its samples, may run, or zero for the default.
+	Timeout int
+	// Samples is the number of times the probe runs in a single
+	// measurement, Interval seconds apart.
+	Samples  int
+	Interval float64
+	// Targets, when not empty, are measured one by one, each appended to
+	// Arguments as the last argument.
+	Targets []string
+	// RegionArguments overrides Arguments for the given regions.
+	RegionArguments map[string]string
+}
+
+// ArgumentsFor returns the arguments of the probe in the given region.
+func (m *TaskMetadata) ArgumentsFor(region string) string {
+	if args, ok := m.RegionArguments[region]; ok {
+		return args
+	}
+	return m.Arguments
 }
 
 func NewTaskMetadata() (*TaskMetadata, error) {
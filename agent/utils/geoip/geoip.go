@@ -0,0 +1,130 @@
+package geoip
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// The names of the MaxMind-format databases looked up in the directory.
+const (
+	CityDatabase = "GeoLite2-City.mmdb"
+	ASNDatabase  = "GeoLite2-ASN.mmdb"
+)
+
+// Info holds what the databases know about an IP address.
+type Info struct {
+	Country        string  `json:"country,omitempty"`
+	CountryCode    string  `json:"country_code,omitempty"`
+	City           string  `json:"city,omitempty"`
+	Latitude       float64 `json:"latitude,omitempty"`
+	Longitude      float64 `json:"longitude,omitempty"`
+	ASN            uint    `json:"asn,omitempty"`
+	ASOrganization string  `json:"as_organization,omitempty"`
+}
+
+type cityRecord struct {
+	Country struct {
+		ISOCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Location struct {
+		Latitude  float64 `maxminddb:"latitude"`
+		Longitude float64 `maxminddb:"longitude"`
+	} `maxminddb:"location"`
+}
+
+type asnRecord struct {
+	Number       uint   `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+// DB looks addresses up in local databases. Either database may be
+// missing, in which case the corresponding fields are left empty.
+type DB struct {
+	city *maxminddb.Reader
+	asn  *maxminddb.Reader
+}
+
+// Open opens the databases found in dir. It fails only when none of them
+// can be opened.
+func Open(dir string) (*DB, error) {
+	db := &DB{}
+
+	var err error
+	db.city, err = open(filepath.Join(dir, CityDatabase))
+	if err != nil {
+		return nil, err
+	}
+	db.asn, err = open(filepath.Join(dir, ASNDatabase))
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if db.city == nil && db.asn == nil {
+		return nil, fmt.Errorf("No database found in '%s'", dir)
+	}
+	return db, nil
+}
+
+// open opens a database, returning a nil reader when it does not exist.
+func open(name string) (*maxminddb.Reader, error) {
+	r, err := maxminddb.Open(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("maxminddb.Open -> %w", err)
+	}
+	return r, nil
+}
+
+// Lookup returns what the databases know about ip.
+func (db *DB) Lookup(ip net.IP) (*Info, error) {
+	info := &Info{}
+
+	if db.city != nil {
+		var record cityRecord
+		if err := db.city.Lookup(ip, &record); err != nil {
+			return nil, fmt.Errorf("db.city.Lookup -> %w", err)
+		}
+		info.Country = record.Country.Names["en"]
+		info.CountryCode = record.Country.ISOCode
+		info.City = record.City.Names["en"]
+		info.Latitude = record.Location.Latitude
+		info.Longitude = record.Location.Longitude
+	}
+
+	if db.asn != nil {
+		var record asnRecord
+		if err := db.asn.Lookup(ip, &record); err != nil {
+			return nil, fmt.Errorf("db.asn.Lookup -> %w", err)
+		}
+		info.ASN = record.Number
+		info.ASOrganization = record.Organization
+	}
+
+	return info, nil
+}
+
+// Close releases the databases.
+func (db *DB) Close() error {
+	var err error
+	if db.city != nil {
+		err = db.city.Close()
+	}
+	if db.asn != nil {
+		if e := db.asn.Close(); e != nil {
+			err = e
+		}
+	}
+	return err
+}
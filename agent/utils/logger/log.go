@@ -1,8 +1,10 @@
 package logger
 
 import (
+	"context"
 	"encoding/json"
 	"log"
+	"sync"
 )
 
 // Inspired from https://cloud.google.com/run/docs/logging
@@ -12,12 +14,15 @@ type Entry struct {
 	Message  string `json:"message"`
 	Severity string `json:"severity,omitempty"`
 	Trace    string `json:"logging.googleapis.com/trace,omitempty"`
+	SpanID   string `json:"logging.googleapis.com/spanId,omitempty"`
 
 	// Logs Explorer allows filtering and display of this as `jsonPayload.component`.
 	Component         string `json:"component,omitempty"`
 	BenchmarkID       int64  `json:"benchmark_id,omitempty"`
 	BenchmarkSequence int64  `json:"benchmark_sequence,omitempty"`
-	// TaskID            string `json:"task_id,omitempty"`
+	TaskID            string `json:"task_id,omitempty"`
+	Region            string `json:"region,omitempty"`
+	Sequence          int    `json:"sequence,omitempty"`
 }
 
 // String renders an entry structure to the JSON format expected by Cloud Logging.
@@ -31,3 +36,64 @@ func (e Entry) String() string {
 	}
 	return string(out)
 }
+
+// Logger writes entries that belong to a single request. It fills in the
+// fields identifying the request, so that concurrent requests never log
+// under each other's trace.
+type Logger struct {
+	mu       sync.Mutex
+	trace    string
+	spanID   string
+	region   string
+	taskID   string
+	sequence int
+}
+
+// New returns a logger for a request of the given trace and span, in the
+// given region.
+func New(trace, spanID, region string) *Logger {
+	return &Logger{trace: trace, spanID: spanID, region: region}
+}
+
+// SetTaskID sets the task that the request measures, once it is known.
+func (l *Logger) SetTaskID(taskID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.taskID = taskID
+}
+
+// SetSequence sets the sequence number of the measurement, once it has been
+// allocated.
+func (l *Logger) SetSequence(sequence int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sequence = sequence
+}
+
+// Log writes the entry with the fields of the request. A nil logger writes
+// the entry as it is.
+func (l *Logger) Log(e Entry) {
+	if l != nil {
+		l.mu.Lock()
+		e.Trace = l.trace
+		e.SpanID = l.spanID
+		e.Region = l.region
+		e.TaskID = l.taskID
+		e.Sequence = l.sequence
+		l.mu.Unlock()
+	}
+	log.Println(e)
+}
+
+type contextKey struct{}
+
+// WithLogger returns a copy of ctx carrying the logger.
+func WithLogger(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the logger carried by ctx, or nil if there is none.
+func FromContext(ctx context.Context) *Logger {
+	l, _ := ctx.Value(contextKey{}).(*Logger)
+	return l
+}
@@ -0,0 +1,38 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoggerFillsRequestFields(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	defer log.SetOutput(os.Stderr)
+
+	l := New("projects/p/traces/t", "s", "europe-west1")
+	l.SetTaskID("task")
+	l.Log(Entry{Message: "before"})
+	l.SetSequence(7)
+	l.Log(Entry{Message: "after"})
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	if !assert.Len(t, lines, 2, "Every entry must be logged.") {
+		return
+	}
+
+	var before, after map[string]interface{}
+	assert.Nil(t, json.Unmarshal(lines[0], &before), "The entry must be JSON.")
+	assert.Nil(t, json.Unmarshal(lines[1], &after), "The entry must be JSON.")
+
+	assert.Equal(t, "task", before["task_id"], "The task must be logged.")
+	assert.Equal(t, "europe-west1", before["region"], "The region must be logged.")
+	assert.NotContains(t, before, "sequence", "No sequence must be logged before it is known.")
+	assert.Equal(t, 7.0, after["sequence"], "The sequence must be logged once it is known.")
+}
@@ -0,0 +1,111 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const defaultServiceName = "measurement-measurer"
+
+// Setup installs the global tracer provider. Spans are exported over
+// OTLP/HTTP when OTEL_EXPORTER_OTLP_TRACES_ENDPOINT or
+// OTEL_EXPORTER_OTLP_ENDPOINT is set, and dropped otherwise; the exporter
+// reads the rest of its configuration, such as OTEL_EXPORTER_OTLP_HEADERS,
+// from the environment. The returned function flushes the remaining spans.
+func Setup(region string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	if os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("otlptracehttp.New -> %w", err)
+	}
+
+	serviceName := os.Getenv("OTEL_SERVICE_NAME")
+	if serviceName == "" {
+		serviceName = defaultServiceName
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		semconv.ServiceNameKey.String(serviceName),
+		semconv.CloudRegionKey.String(region),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("resource.Merge -> %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Extract returns a copy of ctx with the span context that the caller sent
+// in the W3C traceparent header or, failing that, in the
+// X-Cloud-Trace-Context header set by Google Cloud.
+func Extract(ctx context.Context, header http.Header) context.Context {
+	ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(header))
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx
+	}
+
+	sc, err := parseCloudTraceContext(header.Get("X-Cloud-Trace-Context"))
+	if err != nil {
+		return ctx
+	}
+	return trace.ContextWithRemoteSpanContext(ctx, sc)
+}
+
+// parseCloudTraceContext parses the "TRACE_ID/SPAN_ID;o=OPTIONS" format of
+// the X-Cloud-Trace-Context header, where the span ID is a decimal number.
+func parseCloudTraceContext(s string) (trace.SpanContext, error) {
+	if s == "" {
+		return trace.SpanContext{}, errors.New("The header is empty")
+	}
+
+	s, options, _ := strings.Cut(s, ";")
+	traceHex, spanDec, _ := strings.Cut(s, "/")
+
+	traceID, err := trace.TraceIDFromHex(traceHex)
+	if err != nil {
+		return trace.SpanContext{}, fmt.Errorf("trace.TraceIDFromHex -> %w", err)
+	}
+
+	var spanID trace.SpanID
+	n, err := strconv.ParseUint(spanDec, 10, 64)
+	if err != nil {
+		return trace.SpanContext{}, fmt.Errorf("strconv.ParseUint -> %w", err)
+	}
+	for i := len(spanID) - 1; i >= 0; i-- {
+		spanID[i] = byte(n)
+		n >>= 8
+	}
+
+	config := trace.SpanContextConfig{TraceID: traceID, SpanID: spanID, Remote: true}
+	if options == "o=1" {
+		config.TraceFlags = trace.FlagsSampled
+	}
+
+	sc := trace.NewSpanContext(config)
+	if !sc.IsValid() {
+		return trace.SpanContext{}, errors.New("The span context is invalid")
+	}
+	return sc, nil
+}
@@ -0,0 +1,89 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestParseCloudTraceContext(t *testing.T) {
+	tests := []struct {
+		header  string
+		valid   bool
+		traceID string
+		spanID  string
+		sampled bool
+	}{
+		{header: "105445aa7843bc8bf206b12000100000/1;o=1", valid: true, traceID: "105445aa7843bc8bf206b12000100000", spanID: "0000000000000001", sampled: true},
+		{header: "105445aa7843bc8bf206b12000100000/18446744073709551615;o=0", valid: true, traceID: "105445aa7843bc8bf206b12000100000", spanID: "ffffffffffffffff"},
+		{header: "105445aa7843bc8bf206b12000100000/4660", valid: true, traceID: "105445aa7843bc8bf206b12000100000", spanID: "0000000000001234"},
+		{header: ""},
+		{header: "105445aa7843bc8bf206b12000100000"},
+		{header: "105445aa7843bc8bf206b12000100000/"},
+		{header: "105445aa7843bc8bf206b12000100000/0;o=1"},
+		{header: "105445aa7843bc8bf206b12000100000/18446744073709551616"},
+		{header: "105445aa7843bc8bf206b12000100000/12ab"},
+		{header: "00000000000000000000000000000000/1;o=1"},
+		{header: "105445aa7843bc8b/1;o=1"},
+		{header: "not a trace/1"},
+	}
+
+	for _, tt := range tests {
+		sc, err := parseCloudTraceContext(tt.header)
+		if !tt.valid {
+			assert.NotNil(t, err, "An invalid header must be rejected: %q", tt.header)
+			continue
+		}
+		if assert.Nil(t, err, "A valid header must be parsed: %q", tt.header) {
+			assert.Equal(t, tt.traceID, sc.TraceID().String(), "The trace ID must be parsed: %q", tt.header)
+			assert.Equal(t, tt.spanID, sc.SpanID().String(), "The decimal span ID must be parsed: %q", tt.header)
+			assert.Equal(t, tt.sampled, sc.IsSampled(), "The sampling option must be parsed: %q", tt.header)
+			assert.True(t, sc.IsRemote(), "The span context must be remote: %q", tt.header)
+		}
+	}
+}
+
+func TestExtract(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	header := http.Header{}
+	header.Set("X-Cloud-Trace-Context", "105445aa7843bc8bf206b12000100000/1;o=1")
+	sc := trace.SpanContextFromContext(Extract(context.Background(), header))
+	assert.Equal(t, "105445aa7843bc8bf206b12000100000", sc.TraceID().String(), "The Cloud Trace header must be used without traceparent.")
+
+	header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	sc = trace.SpanContextFromContext(Extract(context.Background(), header))
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID().String(), "The traceparent header must take precedence.")
+	assert.Equal(t, "00f067aa0ba902b7", sc.SpanID().String(), "The traceparent header must take precedence.")
+}
+
+func TestSetupExportsSpans(t *testing.T) {
+	var exported int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/traces" && r.Header.Get("Authorization") == "Bearer token" {
+			atomic.AddInt32(&exported, 1)
+		}
+	}))
+	defer server.Close()
+
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", server.URL)
+	t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "Authorization=Bearer token")
+	shutdown, err := Setup("europe-west1")
+	if !assert.Nil(t, err, "The tracer provider must be set up.") {
+		return
+	}
+
+	_, span := otel.Tracer("test").Start(context.Background(), "span")
+	span.End()
+
+	assert.Nil(t, shutdown(context.Background()), "The spans must be flushed.")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&exported), "The spans must be exported to the endpoint with the headers.")
+}
@@ -0,0 +1,545 @@
+package p
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// splitArguments breaks the task arguments into fields the way a POSIX
+// shell would, honouring single quotes, double quotes and backslashes,
+// without expanding anything.
+func splitArguments(args string) ([]string, error) {
+	fields := make([]string, 0)
+
+	var current strings.Builder
+	inField := false
+	var quote rune
+	escaped := false
+
+	for _, r := range args {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else if r == '\\' && quote == '"' {
+				escaped = true
+			} else {
+				current.WriteRune(r)
+			}
+
+		case r == '\\':
+			escaped = true
+			inField = true
+
+		case r == '\'' || r == '"':
+			quote = r
+			inField = true
+
+		case unicode.IsSpace(r):
+			if inField {
+				fields = append(fields, current.String())
+				current.Reset()
+				inField = false
+			}
+
+		default:
+			current.WriteRune(r)
+			inField = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("Unterminated %c quote in the arguments.", quote)
+	}
+	if escaped {
+		return nil, errors.New("The arguments end with a dangling backslash.")
+	}
+	if inField {
+		fields = append(fields, current.String())
+	}
+	return fields, nil
+}
+
+// argumentOption describes an option accepted by a measurement probe.
+type argumentOption struct {
+	// takesValue is set when the option consumes the next argument.
+	takesValue bool
+	// validate, if set, checks the value of the option.
+	validate func(value string) error
+}
+
+// argumentSchema mirrors the arguments accepted by a measurement probe of
+// the agent, with the same limits on their values, so that invalid tasks
+// are rejected before they are stored and scheduled. The agent validates
+// the arguments again before running them.
+type argumentSchema struct {
+	options map[string]argumentOption
+	// goFlags is set for the native probes of the agent, which parse their
+	// arguments with the flag package: options may start with one or two
+	// dashes and the first positional argument ends the options.
+	goFlags bool
+	// check validates the arguments as a whole and returns the hosts that
+	// the probe connects to, which are checked against the target policy.
+	check func(parsed *parsedArguments) ([]string, error)
+}
+
+var argumentSchemas = map[string]argumentSchema{
+	"null": {
+		check: func(parsed *parsedArguments) ([]string, error) { return nil, nil },
+	},
+	"ping": {
+		options: map[string]argumentOption{
+			"c": {takesValue: true, validate: intBetween(1, 100)},
+			"i": {takesValue: true, validate: secondsBetween(0.01, 10)},
+			"s": {takesValue: true, validate: intBetween(0, 65400)},
+			"t": {takesValue: true, validate: intBetween(1, 255)},
+			"W": {takesValue: true, validate: secondsBetween(0.1, 30)},
+			"4": {},
+			"6": {},
+		},
+		goFlags: true,
+		check:   checkHost,
+	},
+	"traceroute": {
+		options: map[string]argumentOption{
+			"M": {takesValue: true, validate: oneOf("udp", "icmp", "tcp")},
+			"f": {takesValue: true, validate: intBetween(1, 64)},
+			"m": {takesValue: true, validate: intBetween(1, 64)},
+			"q": {takesValue: true, validate: intBetween(1, 10)},
+			"p": {takesValue: true, validate: intBetween(0, 65535)},
+			"w": {takesValue: true, validate: secondsBetween(0.1, 10)},
+			"4": {},
+			"6": {},
+		},
+		goFlags: true,
+		check:   checkTracerouteArguments,
+	},
+	"dns": {
+		options: map[string]argumentOption{
+			"t": {takesValue: true, validate: oneOf("A", "AAAA", "CNAME", "MX", "TXT", "NS", "SOA", "HTTPS")},
+			"s": {takesValue: true},
+			"T": {takesValue: true, validate: oneOf("udp", "tcp", "dot", "doh")},
+			"W": {takesValue: true, validate: secondsBetween(0.1, 30)},
+		},
+		goFlags: true,
+		check:   checkDNSArguments,
+	},
+	"tls": {
+		options: map[string]argumentOption{
+			"sni":    {takesValue: true},
+			"alpn":   {takesValue: true},
+			"expiry": {takesValue: true, validate: intBetween(0, 3650)},
+			"W":      {takesValue: true, validate: secondsBetween(0.1, 60)},
+			"4":      {},
+			"6":      {},
+		},
+		goFlags: true,
+		check:   checkTLSArguments,
+	},
+	"tcp": {
+		options: map[string]argumentOption{
+			"c": {takesValue: true, validate: intBetween(1, 100)},
+			"i": {takesValue: true, validate: secondsBetween(0.01, 10)},
+			"W": {takesValue: true, validate: secondsBetween(0.1, 30)},
+			"4": {},
+			"6": {},
+		},
+		goFlags: true,
+		check:   checkTCPArguments,
+	},
+	"httpstat": {
+		options: map[string]argumentOption{
+			"X":          {takesValue: true, validate: oneOf("GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS")},
+			"H":          {takesValue: true, validate: validateHeader},
+			"d":          {takesValue: true},
+			"max-redirs": {takesValue: true, validate: intBetween(0, 30)},
+			"http1.1":    {},
+			"http2":      {},
+			"k":          {},
+			"m":          {takesValue: true, validate: secondsBetween(0.1, 120)},
+			"max-body":   {takesValue: true, validate: intBetween(0, math.MaxInt64)},
+			"capture":    {takesValue: true},
+		},
+		goFlags: true,
+		check:   checkHTTPArguments,
+	},
+	"curl": {
+		options: map[string]argumentOption{
+			"-4": {}, "-6": {}, "-I": {}, "--head": {}, "-L": {}, "--location": {},
+			"-k": {}, "--insecure": {}, "--compressed": {}, "--http1.0": {}, "--http1.1": {},
+			"--http2": {}, "--tlsv1.2": {}, "--tlsv1.3": {},
+			"-X":                {takesValue: true},
+			"--request":         {takesValue: true},
+			"-H":                {takesValue: true, validate: rejectFile},
+			"--header":          {takesValue: true, validate: rejectFile},
+			"-A":                {takesValue: true},
+			"--user-agent":      {takesValue: true},
+			"-e":                {takesValue: true},
+			"--referer":         {takesValue: true},
+			"-d":                {takesValue: true, validate: rejectFile},
+			"--data":            {takesValue: true, validate: rejectFile},
+			"--data-raw":        {takesValue: true},
+			"--max-redirs":      {takesValue: true, validate: intBetween(-1, math.MaxInt64)},
+			"-m":                {takesValue: true, validate: positiveSeconds(120)},
+			"--max-time":        {takesValue: true, validate: positiveSeconds(120)},
+			"--connect-timeout": {takesValue: true, validate: positiveSeconds(60)},
+		},
+		check: checkURL,
+	},
+}
+
+// maxTaskTargets is the largest number of targets in a single task.
+const maxTaskTargets = 100
+
+// validateArguments checks the arguments of a task against the schema of
+// its probe, and the hosts they reach against the target policy. When the
+// task has a list of targets, each of them is appended to the arguments in
+// turn, the way the agent does.
+func validateArguments(probe, args string, targets []string) error {
+	schema, ok := argumentSchemas[probe]
+	if !ok {
+		return fmt.Errorf("The measurement probe is not supported: '%s'", probe)
+	}
+
+	fields, err := splitArguments(args)
+	if err != nil {
+		return err
+	}
+
+	if len(targets) == 0 {
+		return validateFields(probe, schema, fields)
+	}
+	if len(targets) > maxTaskTargets {
+		return fmt.Errorf("A task cannot have more than %d targets, got %d.", maxTaskTargets, len(targets))
+	}
+
+	seen := make(map[string]bool, len(targets))
+	for _, target := range targets {
+		if strings.TrimSpace(target) == "" {
+			return errors.New("The targets cannot be empty.")
+		}
+		if seen[target] {
+			return fmt.Errorf("The target is duplicated: '%s'", target)
+		}
+		seen[target] = true
+
+		if err := validateFields(probe, schema, append(fields[:len(fields):len(fields)], target)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// argumentErrorCode is the status of a task whose arguments are rejected:
+// 403 when the target policy denies a target, and 400 otherwise.
+func argumentErrorCode(err error) int {
+	if errors.Is(err, errTargetDenied) {
+		return http.StatusForbidden
+	}
+	return http.StatusBadRequest
+}
+
+// validateRegionArguments checks that every region with its own arguments
+// is one of the vantage points of the task, and that the arguments are
+// valid for the probe.
+func validateRegionArguments(t *task) error {
+	for region, args := range t.RegionArguments {
+		found := false
+		for _, vantagePoint := range t.VantagePoints {
+			if vantagePoint == region {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("The region with its own arguments is not a vantage point of the task: '%s'", region)
+		}
+		if err := validateArguments(t.Probe, args, t.Targets); err != nil {
+			return fmt.Errorf("%s: %w", region, err)
+		}
+	}
+	return nil
+}
+
+// parsedArguments holds what validateFields found in the arguments.
+type parsedArguments struct {
+	positional []string
+	// values maps the name of an option, without dashes for the native
+	// probes, to its last value.
+	values map[string]string
+}
+
+// validateFields checks the split arguments against the schema of a probe,
+// then the hosts they reach against the target policy.
+func validateFields(probe string, schema argumentSchema, fields []string) error {
+	parsed := &parsedArguments{positional: make([]string, 0), values: make(map[string]string)}
+	for i := 0; i < len(fields); i++ {
+		field := fields[i]
+		if field == "--" {
+			parsed.positional = append(parsed.positional, fields[i+1:]...)
+			break
+		}
+		if !strings.HasPrefix(field, "-") || field == "-" {
+			if schema.goFlags {
+				parsed.positional = append(parsed.positional, fields[i:]...)
+				break
+			}
+			parsed.positional = append(parsed.positional, field)
+			continue
+		}
+
+		name, value, hasValue := field, "", false
+		if schema.goFlags {
+			name = strings.TrimPrefix(strings.TrimPrefix(name, "-"), "-")
+		}
+		if j := strings.Index(name, "="); j >= 0 && (schema.goFlags || strings.HasPrefix(name, "--")) {
+			name, value, hasValue = name[:j], name[j+1:], true
+		}
+
+		option, ok := schema.options[name]
+		if !ok {
+			return fmt.Errorf("The option is not allowed for the '%s' probe: '%s'", probe, field)
+		}
+		if !option.takesValue {
+			parsed.values[name] = ""
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(fields) {
+				return fmt.Errorf("The option requires a value: '%s'", field)
+			}
+			i++
+			value = fields[i]
+		}
+		if option.validate != nil {
+			if err := option.validate(value); err != nil {
+				return fmt.Errorf("Invalid value for '%s': %w", field, err)
+			}
+		}
+		parsed.values[name] = value
+	}
+
+	if _, ipv4 := parsed.values["4"]; ipv4 {
+		if _, ipv6 := parsed.values["6"]; ipv6 {
+			return errors.New("The -4 and -6 options are mutually exclusive.")
+		}
+	}
+
+	hosts, err := schema.check(parsed)
+	if err != nil {
+		return err
+	}
+	for _, host := range hosts {
+		if err := policy.checkHost(host); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func intBetween(min, max int64) func(string) error {
+	return func(value string) error {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < min || n > max {
+			return fmt.Errorf("must be an integer between %d and %d", min, max)
+		}
+		return nil
+	}
+}
+
+func secondsBetween(min, max float64) func(string) error {
+	return func(value string) error {
+		s, err := strconv.ParseFloat(value, 64)
+		if err != nil || s < min || s > max {
+			return fmt.Errorf("must be a number of seconds between %g and %g", min, max)
+		}
+		return nil
+	}
+}
+
+// positiveSeconds accepts the timeouts of curl, which must be positive.
+func positiveSeconds(max float64) func(string) error {
+	return func(value string) error {
+		s, err := strconv.ParseFloat(value, 64)
+		if err != nil || s <= 0 || s > max {
+			return fmt.Errorf("must be a number of seconds between 0 and %g", max)
+		}
+		return nil
+	}
+}
+
+// oneOf accepts any of values, ignoring the case like the probes do.
+func oneOf(values ...string) func(string) error {
+	return func(value string) error {
+		for _, v := range values {
+			if strings.EqualFold(v, value) {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of [%s]", strings.Join(values, "|"))
+	}
+}
+
+// rejectFile rejects the values that curl would read from a file.
+func rejectFile(value string) error {
+	if strings.HasPrefix(value, "@") {
+		return errors.New("reading a value from a file is not allowed")
+	}
+	return nil
+}
+
+func validateHeader(value string) error {
+	if !strings.Contains(value, ":") {
+		return errors.New("must be in the 'Name: value' format")
+	}
+	return nil
+}
+
+// onePositional returns the only positional argument, described by what
+// in the error otherwise.
+func onePositional(parsed *parsedArguments, what string) (string, error) {
+	if len(parsed.positional) != 1 {
+		return "", fmt.Errorf("The arguments must contain exactly one %s.", what)
+	}
+	return parsed.positional[0], nil
+}
+
+func checkHost(parsed *parsedArguments) ([]string, error) {
+	host, err := onePositional(parsed, "destination host")
+	if err != nil {
+		return nil, err
+	}
+	return []string{host}, nil
+}
+
+func checkTracerouteArguments(parsed *parsedArguments) ([]string, error) {
+	first, max := 1, 30
+	if v, ok := parsed.values["f"]; ok {
+		first, _ = strconv.Atoi(v)
+	}
+	if v, ok := parsed.values["m"]; ok {
+		max, _ = strconv.Atoi(v)
+	}
+	if first > max {
+		return nil, fmt.Errorf("The first TTL must be between 1 and the maximum TTL: %d", first)
+	}
+	return checkHost(parsed)
+}
+
+// checkDNSArguments returns the resolver set with -s, if any, since the
+// query name is not connected to.
+func checkDNSArguments(parsed *parsedArguments) ([]string, error) {
+	name, err := onePositional(parsed, "query name")
+	if err != nil {
+		return nil, err
+	}
+	if !isDomainName(name) {
+		return nil, fmt.Errorf("The query name is invalid: '%s'", name)
+	}
+
+	resolver, ok := parsed.values["s"]
+	if !ok || resolver == "" {
+		return nil, nil
+	}
+	if strings.EqualFold(parsed.values["T"], "doh") {
+		u, err := url.Parse(resolver)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return nil, fmt.Errorf("The DoH resolver must be an https URL: '%s'", resolver)
+		}
+		return []string{u.Hostname()}, nil
+	}
+	return []string{hostOf(resolver)}, nil
+}
+
+func checkTLSArguments(parsed *parsedArguments) ([]string, error) {
+	target, err := onePositional(parsed, "host[:port]")
+	if err != nil {
+		return nil, err
+	}
+	host := hostOf(target)
+	if host == "" {
+		return nil, fmt.Errorf("The destination is invalid: '%s'", target)
+	}
+	return []string{host}, nil
+}
+
+func checkTCPArguments(parsed *parsedArguments) ([]string, error) {
+	target, err := onePositional(parsed, "host:port")
+	if err != nil {
+		return nil, err
+	}
+	host, port, err := net.SplitHostPort(target)
+	if err != nil || host == "" || port == "" {
+		return nil, fmt.Errorf("The destination must be in the host:port format: '%s'", target)
+	}
+	if _, err := net.LookupPort("tcp", port); err != nil {
+		return nil, fmt.Errorf("The port is invalid: '%s'", port)
+	}
+	return []string{host}, nil
+}
+
+func checkHTTPArguments(parsed *parsedArguments) ([]string, error) {
+	hosts, err := checkURL(parsed)
+	if err != nil {
+		return nil, err
+	}
+	_, http1 := parsed.values["http1.1"]
+	_, http2 := parsed.values["http2"]
+	if http1 && http2 {
+		return nil, errors.New("The -http1.1 and -http2 options are mutually exclusive.")
+	}
+	if http2 && !strings.HasPrefix(strings.ToLower(parsed.positional[0]), "https://") {
+		return nil, errors.New("HTTP/2 can only be forced on 'https://' URLs.")
+	}
+	return hosts, nil
+}
+
+func checkURL(parsed *parsedArguments) ([]string, error) {
+	target, err := onePositional(parsed, "URL")
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.New("The arguments must contain URL starts with either 'http://' or 'https://'.")
+	}
+	return []string{u.Hostname()}, nil
+}
+
+// hostOf extracts the host from a "host:port" pair or a bare host.
+func hostOf(target string) string {
+	if host, _, err := net.SplitHostPort(target); err == nil {
+		return host
+	}
+	return strings.Trim(target, "[]")
+}
+
+// isDomainName reports whether name, with or without its trailing dot, fits
+// in a DNS query: the root, or labels of 1 to 63 bytes and at most 253 bytes
+// in total.
+func isDomainName(name string) bool {
+	if name == "." {
+		return true
+	}
+	name = strings.TrimSuffix(name, ".")
+	if name == "" || len(name) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 {
+			return false
+		}
+	}
+	return true
+}
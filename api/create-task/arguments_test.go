@@ -0,0 +1,83 @@
+package p
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateArguments(t *testing.T) {
+	tests := []struct {
+		probe   string
+		args    string
+		targets []string
+		valid   bool
+	}{
+		{probe: "ping", args: "-c 3 google.com", valid: true},
+		{probe: "ping", args: "-c 1000 google.com"},
+		{probe: "ping", args: "-i 0 google.com"},
+		{probe: "ping", args: "-4 -6 google.com"},
+		{probe: "ping", args: "-c 3", targets: []string{"google.com", "example.com"}, valid: true},
+		{probe: "ping", args: "-c 3", targets: []string{"google.com", "google.com"}},
+		{probe: "ping", args: "-c 3", targets: []string{" "}},
+		{probe: "traceroute", args: "-M tcp google.com", valid: true},
+		{probe: "traceroute", args: "-M sctp google.com"},
+		{probe: "traceroute", args: "-f 20 -m 10 google.com"},
+		{probe: "tcp", args: "google.com:443", valid: true},
+		{probe: "tcp", args: "google.com"},
+		{probe: "tls", args: "-expiry 5000 google.com"},
+		{probe: "httpstat", args: "-H Accept google.com"},
+		{probe: "httpstat", args: "-http2 http://google.com/"},
+		{probe: "dns", args: "-T doh -t AAAA google.com", valid: true},
+		{probe: "dns", args: "-T foo google.com"},
+		{probe: "dns", args: "-T doh -s http://dns.google/dns-query google.com"},
+		{probe: "curl", args: "-L --max-redirs 5 https://google.com/", valid: true},
+		{probe: "curl", args: "-o /tmp/out https://google.com/"},
+		{probe: "curl", args: "https://google.com/; rm -rf /"},
+		{probe: "curl", args: "-d @/etc/passwd https://google.com/"},
+		{probe: "curl", args: "--max-time 0 https://google.com/"},
+		{probe: "sctp", args: "google.com"},
+	}
+
+	for _, tt := range tests {
+		err := validateArguments(tt.probe, tt.args, tt.targets)
+		if tt.valid {
+			assert.Nil(t, err, "The arguments must be accepted: %s %q %v", tt.probe, tt.args, tt.targets)
+		} else {
+			assert.NotNil(t, err, "The arguments must be rejected: %s %q %v", tt.probe, tt.args, tt.targets)
+			assert.Equal(t, http.StatusBadRequest, argumentErrorCode(err), "Invalid arguments must be a bad request: %s %q", tt.probe, tt.args)
+		}
+	}
+}
+
+func TestValidateArgumentsTargetPolicy(t *testing.T) {
+	tests := []struct {
+		task   task
+		denied bool
+	}{
+		{task: task{Probe: "ping", Arguments: "-c 3 google.com"}},
+		{task: task{Probe: "ping", Arguments: "-c 3 169.254.169.254"}, denied: true},
+		{task: task{Probe: "tcp", Targets: []string{"google.com:443", "10.0.0.1:22"}}, denied: true},
+		{task: task{Probe: "curl", Arguments: "-L http://metadata.google.internal/"}, denied: true},
+		{task: task{Probe: "dns", Arguments: "-s 127.0.0.1 google.com"}, denied: true},
+		{task: task{Probe: "dns", Arguments: "google.com"}},
+		{task: task{Probe: "tls", Arguments: "[2002:a9fe:a9fe::1]:443"}, denied: true},
+		{task: task{Probe: "httpstat", Arguments: "http://[2001:0:4136:e378:8000:63bf:3fff:fdd2]/"}, denied: true},
+		{task: task{Probe: "ping", Arguments: "google.com", VantagePoints: []string{"europe-west1"}, RegionArguments: map[string]string{"europe-west1": "192.168.1.1"}}, denied: true},
+	}
+
+	for _, tt := range tests {
+		err := validateArguments(tt.task.Probe, tt.task.Arguments, tt.task.Targets)
+		if err == nil {
+			err = validateRegionArguments(&tt.task)
+		}
+		if tt.denied {
+			assert.True(t, errors.Is(err, errTargetDenied), "The target must be denied: %+v", tt.task)
+			assert.Equal(t, http.StatusForbidden, argumentErrorCode(err), "A denied target must be forbidden: %+v", tt.task)
+		} else {
+			assert.Nil(t, err, "The target must be allowed: %+v", tt.task)
+		}
+	}
+}
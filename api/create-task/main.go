@@ -126,6 +126,54 @@ func CreateTask(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		if err := validateArguments(t.Probe, t.Arguments, t.Targets); err != nil {
+			log.Println(Entry{
+				TaskID:    taskID,
+				Severity:  "ERROR",
+				Message:   fmt.Errorf("validateArguments -> %w", err).Error(),
+				Component: "arguments",
+				Trace:     trace,
+			})
+			sendRespond(w, argumentErrorCode(err), err.Error())
+			return
+		}
+
+		if err := validateRegionArguments(t); err != nil {
+			log.Println(Entry{
+				TaskID:    taskID,
+				Severity:  "ERROR",
+				Message:   fmt.Errorf("validateRegionArguments -> %w", err).Error(),
+				Component: "arguments",
+				Trace:     trace,
+			})
+			sendRespond(w, argumentErrorCode(err), err.Error())
+			return
+		}
+
+		if err := validateTimeout(t.Timeout, len(t.Targets)); err != nil {
+			log.Println(Entry{
+				TaskID:    taskID,
+				Severity:  "ERROR",
+				Message:   fmt.Errorf("validateTimeout -> %w", err).Error(),
+				Component: "body",
+				Trace:     trace,
+			})
+			sendRespond(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if err := validateSampling(t.Samples, t.Interval, t.Timeout); err != nil {
+			log.Println(Entry{
+				TaskID:    taskID,
+				Severity:  "ERROR",
+				Message:   fmt.Errorf("validateSampling -> %w", err).Error(),
+				Component: "body",
+				Trace:     trace,
+			})
+			sendRespond(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
 		receivedPayload, err := json.Marshal(t)
 		if err != nil {
 			log.Println(Entry{
@@ -248,9 +296,11 @@ func CreateTask(w http.ResponseWriter, r *http.Request) {
 						return
 					}
 
+					// The key lets the agent ignore a duplicate of this request.
 					httpRequestBody, err := json.Marshal(&struct {
-						ID string `json:"id"`
-					}{ID: taskID})
+						ID  string `json:"id"`
+						Key string `json:"key"`
+					}{ID: taskID, Key: "one-off"})
 					if err != nil {
 						msg := fmt.Errorf("%s: %w", vantagePoint, err)
 						log.Println(Entry{
@@ -0,0 +1,180 @@
+package p
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+)
+
+// errTargetDenied is returned, wrapped, when the target policy does not
+// allow a task to reach an address or a hostname.
+var errTargetDenied = errors.New("The target is not allowed")
+
+// The default deny lists mirror those of the agent, which enforces the
+// policy again once the hostnames are resolved.
+var defaultDeniedCIDRs = []string{
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"64:ff9b::/96",
+	"2001::/32",
+	"2002::/16",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+}
+
+var defaultDeniedHosts = []string{
+	"localhost",
+	"*.localhost",
+	"*.internal",
+}
+
+// targetPolicy decides which addresses and hostnames a task may reach. A
+// target is denied when it matches a deny entry and no allow entry.
+type targetPolicy struct {
+	deniedNets   []*net.IPNet
+	allowedNets  []*net.IPNet
+	deniedHosts  []string
+	allowedHosts []string
+}
+
+var policy *targetPolicy
+
+func init() {
+	p, err := targetPolicyFromEnv()
+	if err != nil {
+		log.Println(Entry{
+			Severity:  "CRITICAL",
+			Message:   fmt.Errorf("targetPolicyFromEnv -> %w", err).Error(),
+			Component: "policy",
+		})
+		p, _ = newTargetPolicy(defaultDeniedCIDRs, nil, defaultDeniedHosts, nil)
+	}
+	policy = p
+}
+
+// newTargetPolicy builds a policy from CIDR blocks and hostname patterns. A
+// pattern is either a hostname or "*." followed by a domain, which matches
+// every name below the domain.
+func newTargetPolicy(deniedCIDRs, allowedCIDRs, deniedHosts, allowedHosts []string) (*targetPolicy, error) {
+	p := &targetPolicy{
+		deniedHosts:  normalizeHosts(deniedHosts),
+		allowedHosts: normalizeHosts(allowedHosts),
+	}
+
+	var err error
+	if p.deniedNets, err = parseCIDRs(deniedCIDRs); err != nil {
+		return nil, fmt.Errorf("parseCIDRs -> %w", err)
+	}
+	if p.allowedNets, err = parseCIDRs(allowedCIDRs); err != nil {
+		return nil, fmt.Errorf("parseCIDRs -> %w", err)
+	}
+	return p, nil
+}
+
+// targetPolicyFromEnv builds a policy from the comma-separated lists in
+// TARGET_DENY_CIDRS, TARGET_ALLOW_CIDRS, TARGET_DENY_HOSTS and
+// TARGET_ALLOW_HOSTS, the same variables as the agent reads.
+func targetPolicyFromEnv() (*targetPolicy, error) {
+	deniedCIDRs := defaultDeniedCIDRs
+	if v, ok := os.LookupEnv("TARGET_DENY_CIDRS"); ok {
+		deniedCIDRs = splitList(v)
+	}
+	deniedHosts := defaultDeniedHosts
+	if v, ok := os.LookupEnv("TARGET_DENY_HOSTS"); ok {
+		deniedHosts = splitList(v)
+	}
+	return newTargetPolicy(deniedCIDRs, splitList(os.Getenv("TARGET_ALLOW_CIDRS")),
+		deniedHosts, splitList(os.Getenv("TARGET_ALLOW_HOSTS")))
+}
+
+// checkHost returns an error wrapping errTargetDenied if the policy does not
+// allow the hostname, or the address when host is an IP literal.
+func (p *targetPolicy) checkHost(host string) error {
+	if ip := net.ParseIP(strings.Trim(host, "[]")); ip != nil {
+		if containsIP(p.allowedNets, ip) || !containsIP(p.deniedNets, ip) {
+			return nil
+		}
+		return fmt.Errorf("%w: '%s'", errTargetDenied, host)
+	}
+
+	name := normalizeHost(host)
+	if matchHost(p.allowedHosts, name) || !matchHost(p.deniedHosts, name) {
+		return nil
+	}
+	return fmt.Errorf("%w: '%s'", errTargetDenied, host)
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("net.ParseCIDR -> %w", err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// containsIP reports whether ip is in any of nets. IPv4-mapped IPv6
+// addresses are compared as IPv4 addresses.
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchHost(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if pattern == name {
+			return true
+		}
+		if strings.HasPrefix(pattern, "*.") && strings.HasSuffix(name, pattern[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+}
+
+func normalizeHosts(hosts []string) []string {
+	normalized := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		normalized = append(normalized, normalizeHost(host))
+	}
+	return normalized
+}
+
+func splitList(s string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -30,6 +30,62 @@ type task struct {
 	Type             string
 	Status           string
 	NumberOfSequence map[string]int
+	Timeout          int
+	Samples          int
+	Interval         float64
+	Targets          []string
+	RegionArguments  map[string]string
+}
+
+// maxTaskTimeout is the longest a measurement may run, in seconds, all of
+// its targets included, leaving the agent time to store the result before
+// the scheduler gives up on the request after 180 seconds.
+const maxTaskTimeout = 150
+
+// maxConcurrentTargets is the number of targets the agent measures at once,
+// each within the timeout of the task.
+const maxConcurrentTargets = 8
+
+// defaultTaskTimeout is the timeout, in seconds, that the agent applies to
+// a measurement when the task does not set one.
+const defaultTaskTimeout = 60
+
+// maxTaskSamples is the largest number of samples in a single measurement.
+const maxTaskSamples = 100
+
+// validateTimeout checks the timeout of a measurement, or the default
+// timeout of the agent when none is set, and that the targets of the task,
+// measured maxConcurrentTargets at a time, fit within maxTaskTimeout.
+func validateTimeout(timeout, targets int) error {
+	if timeout < 0 || timeout > maxTaskTimeout {
+		return fmt.Errorf("The timeout must be between 0 and %d seconds, got %d.", maxTaskTimeout, timeout)
+	}
+	if timeout == 0 {
+		timeout = defaultTaskTimeout
+	}
+	rounds := (targets + maxConcurrentTargets - 1) / maxConcurrentTargets
+	if rounds > 1 && rounds*timeout > maxTaskTimeout {
+		return fmt.Errorf("The %d targets, measured %d at a time with a timeout of %d seconds, must fit within %d seconds.", targets, maxConcurrentTargets, timeout, maxTaskTimeout)
+	}
+	return nil
+}
+
+// validateSampling checks that the samples of a measurement can all start
+// before its timeout, or the default timeout of the agent when none is set.
+func validateSampling(samples int, interval float64, timeout int) error {
+	if samples < 0 || samples > maxTaskSamples {
+		return fmt.Errorf("The number of samples must be between 0 and %d, got %d.", maxTaskSamples, samples)
+	}
+	if interval < 0 {
+		return fmt.Errorf("The interval between samples cannot be negative, got %g.", interval)
+	}
+	if timeout == 0 {
+		timeout = defaultTaskTimeout
+	}
+	if samples > 1 && float64(samples-1)*interval >= float64(timeout) {
+		return fmt.Errorf("The samples must fit within %d seconds, got %d samples %g seconds apart.", timeout, samples, interval)
+	}
+	return nil
 }
 
 func newTask() (*task, error) {
@@ -0,0 +1,51 @@
+package p
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateTimeout(t *testing.T) {
+	tests := []struct {
+		timeout int
+		targets int
+		valid   bool
+	}{
+		{timeout: 0, targets: 0, valid: true},
+		{timeout: 150, targets: 8, valid: true},
+		{timeout: 151, targets: 0, valid: false},
+		{timeout: 0, targets: 16, valid: true},
+		{timeout: 0, targets: 17, valid: false},
+		{timeout: 75, targets: 16, valid: true},
+		{timeout: 150, targets: 100, valid: false},
+		{timeout: 11, targets: 100, valid: true},
+	}
+
+	for _, tt := range tests {
+		err := validateTimeout(tt.timeout, tt.targets)
+		assert.Equal(t, tt.valid, err == nil, "Every round of targets must fit within the longest timeout: %+v", tt)
+	}
+}
+
+func TestValidateSampling(t *testing.T) {
+	tests := []struct {
+		samples  int
+		interval float64
+		timeout  int
+		valid    bool
+	}{
+		{samples: 0, interval: 0, timeout: 0, valid: true},
+		{samples: 10, interval: 5, timeout: 0, valid: true},
+		{samples: 13, interval: 5, timeout: 0, valid: false},
+		{samples: 13, interval: 5, timeout: 90, valid: true},
+		{samples: 10, interval: 5, timeout: 30, valid: false},
+		{samples: 101, interval: 0, timeout: 0, valid: false},
+		{samples: 2, interval: -1, timeout: 0, valid: false},
+	}
+
+	for _, tt := range tests {
+		err := validateSampling(tt.samples, tt.interval, tt.timeout)
+		assert.Equal(t, tt.valid, err == nil, "The sampling must be validated against the effective timeout: %+v", tt)
+	}
+}
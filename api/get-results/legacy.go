@@ -0,0 +1,207 @@
+package p
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// schemaVersion is the version of the structured results written by the
+// agent. Documents of version 1 hold the raw console output of the probe in
+// a string, and are converted on read.
+const schemaVersion = 2
+
+var (
+	legacyPingHeader  = regexp.MustCompile(`^PING (\S+) \(([^)]+)\): (\d+) data bytes`)
+	legacyPingReply   = regexp.MustCompile(`^(\d+) bytes from ([^:]+): seq=(\d+) ttl=(\d+) time=([\d.]+) ms`)
+	legacyPingSummary = regexp.MustCompile(`^(\d+) packets transmitted, (\d+) packets received, ([\d.]+)% packet loss`)
+	legacyPingRTT     = regexp.MustCompile(`^round-trip min/avg/max(?:/mdev)? = ([\d.]+)/([\d.]+)/([\d.]+)(?:/([\d.]+))? ms`)
+
+	legacyTracerouteHeader = regexp.MustCompile(`^traceroute to (\S+) \(([^)]+)\), (\d+) hops max`)
+	legacyTracerouteHop    = regexp.MustCompile(`^\s*(\d+)\s+(.*)$`)
+
+	legacyHTTPStatLine = regexp.MustCompile(`^(DNS lookup|TCP connection|TLS handshake|Server processing|Content transfer): (\d+) ms`)
+)
+
+// convertLegacyResult turns a version 1 result of the given probe into the
+// structured form of the current schema. The original text is kept under
+// "legacy_output" so that nothing is lost when a line cannot be parsed.
+func convertLegacyResult(probe, output string) map[string]interface{} {
+	var result map[string]interface{}
+	switch probe {
+	case "ping":
+		result = parseLegacyPing(output)
+	case "traceroute":
+		result = parseLegacyTraceroute(output)
+	case "httpstat":
+		result = parseLegacyHTTPStat(output)
+	case "curl":
+		result = parseLegacyCurl(output)
+	default:
+		result = make(map[string]interface{})
+	}
+
+	result["probe"] = probe
+	result["schema_version"] = schemaVersion
+	result["legacy_output"] = output
+	return result
+}
+
+func atof(s string) float64 {
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}
+
+func atoi(s string) int {
+	i, _ := strconv.Atoi(s)
+	return i
+}
+
+// parseLegacyPing parses the output of the busybox "ping -c 1" command.
+func parseLegacyPing(output string) map[string]interface{} {
+	result := map[string]interface{}{}
+	packets := make([]interface{}, 0)
+
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if m := legacyPingHeader.FindStringSubmatch(line); m != nil {
+			result["target"] = m[1]
+			result["address"] = m[2]
+			result["packet_size"] = atoi(m[3])
+		} else if m := legacyPingReply.FindStringSubmatch(line); m != nil {
+			packets = append(packets, map[string]interface{}{
+				"seq":      atoi(m[3]),
+				"received": true,
+				"rtt_ms":   atof(m[5]),
+				"ttl":      atoi(m[4]),
+				"size":     atoi(m[1]),
+			})
+		} else if m := legacyPingSummary.FindStringSubmatch(line); m != nil {
+			result["transmitted"] = atoi(m[1])
+			result["received"] = atoi(m[2])
+			result["loss_percent"] = atof(m[3])
+		} else if m := legacyPingRTT.FindStringSubmatch(line); m != nil {
+			rtt := map[string]interface{}{
+				"min_ms": atof(m[1]),
+				"avg_ms": atof(m[2]),
+				"max_ms": atof(m[3]),
+			}
+			if m[4] != "" {
+				rtt["mdev_ms"] = atof(m[4])
+			}
+			result["rtt"] = rtt
+		}
+	}
+
+	result["packets"] = packets
+	return result
+}
+
+// parseLegacyTraceroute parses the output of the busybox traceroute
+// command, where each hop line looks like:
+//
+//	2  host (10.0.0.1)  1.234 ms  *  10.0.0.2  2.345 ms !H
+func parseLegacyTraceroute(output string) map[string]interface{} {
+	result := map[string]interface{}{"protocol": "udp"}
+	hops := make([]interface{}, 0)
+
+	for _, line := range strings.Split(output, "\n") {
+		if m := legacyTracerouteHeader.FindStringSubmatch(line); m != nil {
+			result["target"] = m[1]
+			result["address"] = m[2]
+			result["max_ttl"] = atoi(m[3])
+			continue
+		}
+
+		m := legacyTracerouteHop.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+
+		var address, hopAddress string
+		rtts := make([]interface{}, 0)
+		probes := make([]interface{}, 0)
+		timeouts := 0
+
+		fields := strings.Fields(m[2])
+		for i := 0; i < len(fields); i++ {
+			field := fields[i]
+			switch {
+			case field == "*":
+				timeouts++
+				probes = append(probes, map[string]interface{}{"timeout": true})
+
+			case i+1 < len(fields) && fields[i+1] == "ms":
+				rtt := atof(field)
+				rtts = append(rtts, rtt)
+				probes = append(probes, map[string]interface{}{"address": address, "rtt_ms": rtt})
+				if hopAddress == "" {
+					hopAddress = address
+				}
+				i++
+
+			case strings.HasPrefix(field, "!"):
+				if len(probes) > 0 {
+					probes[len(probes)-1].(map[string]interface{})["annotation"] = field
+				}
+
+			case strings.HasPrefix(field, "(") && strings.HasSuffix(field, ")"):
+				address = strings.Trim(field, "()")
+
+			default:
+				address = field
+			}
+		}
+
+		hop := map[string]interface{}{
+			"ttl":      atoi(m[1]),
+			"rtts_ms":  rtts,
+			"timeouts": timeouts,
+			"probes":   probes,
+		}
+		if hopAddress != "" {
+			hop["address"] = hopAddress
+		}
+		hops = append(hops, hop)
+	}
+
+	if len(hops) > 0 {
+		last := hops[len(hops)-1].(map[string]interface{})
+		result["reached"] = last["address"] != nil && last["address"] == result["address"]
+	}
+	result["hops"] = hops
+	return result
+}
+
+// parseLegacyHTTPStat parses the millisecond timings formatted by earlier
+// versions of the httpstat probe.
+func parseLegacyHTTPStat(output string) map[string]interface{} {
+	names := map[string]string{
+		"DNS lookup":        "dns_lookup_ms",
+		"TCP connection":    "tcp_connection_ms",
+		"TLS handshake":     "tls_handshake_ms",
+		"Server processing": "server_processing_ms",
+		"Content transfer":  "content_transfer_ms",
+	}
+
+	timings := map[string]interface{}{}
+	for _, line := range strings.Split(output, "\n") {
+		if m := legacyHTTPStatLine.FindStringSubmatch(strings.TrimSpace(line)); m != nil {
+			timings[names[m[1]]] = atof(m[2])
+		}
+	}
+	return map[string]interface{}{"timings": timings}
+}
+
+// parseLegacyCurl parses the JSON line written by curlt.
+func parseLegacyCurl(output string) map[string]interface{} {
+	result := map[string]interface{}{}
+
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	last := strings.TrimSpace(lines[len(lines)-1])
+	if strings.HasPrefix(last, "{") {
+		json.Unmarshal([]byte(last), &result)
+	}
+	return result
+}
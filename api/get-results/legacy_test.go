@@ -0,0 +1,271 @@
+package p
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLegacyPing(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   map[string]interface{}
+	}{
+		{
+			name: "reply",
+			output: "PING google.com (142.250.185.78): 56 data bytes\n" +
+				"64 bytes from 142.250.185.78: seq=0 ttl=117 time=1.123 ms\n" +
+				"\n" +
+				"--- google.com ping statistics ---\n" +
+				"1 packets transmitted, 1 packets received, 0% packet loss\n" +
+				"round-trip min/avg/max = 1.123/1.123/1.123 ms\n",
+			want: map[string]interface{}{
+				"target":       "google.com",
+				"address":      "142.250.185.78",
+				"packet_size":  56,
+				"transmitted":  1,
+				"received":     1,
+				"loss_percent": 0.0,
+				"rtt": map[string]interface{}{
+					"min_ms": 1.123,
+					"avg_ms": 1.123,
+					"max_ms": 1.123,
+				},
+				"packets": []interface{}{
+					map[string]interface{}{"seq": 0, "received": true, "rtt_ms": 1.123, "ttl": 117, "size": 64},
+				},
+			},
+		},
+		{
+			name: "no reply",
+			output: "PING 192.0.2.1 (192.0.2.1): 56 data bytes\n" +
+				"\n" +
+				"--- 192.0.2.1 ping statistics ---\n" +
+				"1 packets transmitted, 0 packets received, 100% packet loss\n",
+			want: map[string]interface{}{
+				"target":       "192.0.2.1",
+				"address":      "192.0.2.1",
+				"packet_size":  56,
+				"transmitted":  1,
+				"received":     0,
+				"loss_percent": 100.0,
+				"packets":      []interface{}{},
+			},
+		},
+		{
+			name: "truncated",
+			output: "PING google.com (142.250.185.78): 56 data bytes\n" +
+				"64 bytes from 142.250.185.78: seq=0 ttl=117 ti",
+			want: map[string]interface{}{
+				"target":      "google.com",
+				"address":     "142.250.185.78",
+				"packet_size": 56,
+				"packets":     []interface{}{},
+			},
+		},
+		{
+			name:   "garbage",
+			output: "ping: bad address 'google.invalid'\n\x00\xff",
+			want:   map[string]interface{}{"packets": []interface{}{}},
+		},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, parseLegacyPing(tt.output), "The output must be parsed: %s", tt.name)
+	}
+}
+
+func TestParseLegacyTraceroute(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   map[string]interface{}
+	}{
+		{
+			name: "reached",
+			output: "traceroute to google.com (142.250.185.78), 30 hops max, 46 byte packets\n" +
+				" 1  169.254.1.1 (169.254.1.1)  0.367 ms  0.255 ms  0.236 ms\n" +
+				" 2  *  *  *\n" +
+				" 3  108.170.252.1 (108.170.252.1)  1.530 ms  *  142.250.46.249 (142.250.46.249)  1.234 ms !H\n" +
+				" 4  142.250.185.78 (142.250.185.78)  1.101 ms  1.045 ms  1.022 ms\n",
+			want: map[string]interface{}{
+				"protocol": "udp",
+				"target":   "google.com",
+				"address":  "142.250.185.78",
+				"max_ttl":  30,
+				"reached":  true,
+				"hops": []interface{}{
+					map[string]interface{}{
+						"ttl":      1,
+						"address":  "169.254.1.1",
+						"rtts_ms":  []interface{}{0.367, 0.255, 0.236},
+						"timeouts": 0,
+						"probes": []interface{}{
+							map[string]interface{}{"address": "169.254.1.1", "rtt_ms": 0.367},
+							map[string]interface{}{"address": "169.254.1.1", "rtt_ms": 0.255},
+							map[string]interface{}{"address": "169.254.1.1", "rtt_ms": 0.236},
+						},
+					},
+					map[string]interface{}{
+						"ttl":      2,
+						"rtts_ms":  []interface{}{},
+						"timeouts": 3,
+						"probes": []interface{}{
+							map[string]interface{}{"timeout": true},
+							map[string]interface{}{"timeout": true},
+							map[string]interface{}{"timeout": true},
+						},
+					},
+					map[string]interface{}{
+						"ttl":      3,
+						"address":  "108.170.252.1",
+						"rtts_ms":  []interface{}{1.530, 1.234},
+						"timeouts": 1,
+						"probes": []interface{}{
+							map[string]interface{}{"address": "108.170.252.1", "rtt_ms": 1.530},
+							map[string]interface{}{"timeout": true},
+							map[string]interface{}{"address": "142.250.46.249", "rtt_ms": 1.234, "annotation": "!H"},
+						},
+					},
+					map[string]interface{}{
+						"ttl":      4,
+						"address":  "142.250.185.78",
+						"rtts_ms":  []interface{}{1.101, 1.045, 1.022},
+						"timeouts": 0,
+						"probes": []interface{}{
+							map[string]interface{}{"address": "142.250.185.78", "rtt_ms": 1.101},
+							map[string]interface{}{"address": "142.250.185.78", "rtt_ms": 1.045},
+							map[string]interface{}{"address": "142.250.185.78", "rtt_ms": 1.022},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "truncated",
+			output: "traceroute to google.com (142.250.185.78), 30 hops max, 46 byte packets\n" +
+				" 1  169.254.1.1 (169.254.1.1)  0.367 ms  0.2",
+			want: map[string]interface{}{
+				"protocol": "udp",
+				"target":   "google.com",
+				"address":  "142.250.185.78",
+				"max_ttl":  30,
+				"reached":  false,
+				"hops": []interface{}{
+					map[string]interface{}{
+						"ttl":      1,
+						"address":  "169.254.1.1",
+						"rtts_ms":  []interface{}{0.367},
+						"timeouts": 0,
+						"probes": []interface{}{
+							map[string]interface{}{"address": "169.254.1.1", "rtt_ms": 0.367},
+						},
+					},
+				},
+			},
+		},
+		{
+			name:   "garbage",
+			output: "traceroute: bad address 'google.invalid'\n\x00\xff",
+			want: map[string]interface{}{
+				"protocol": "udp",
+				"hops":     []interface{}{},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, parseLegacyTraceroute(tt.output), "The output must be parsed: %s", tt.name)
+	}
+}
+
+func TestParseLegacyHTTPStat(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   map[string]interface{}
+	}{
+		{
+			name: "complete",
+			output: "DNS lookup: 12 ms\n" +
+				"TCP connection: 3 ms\n" +
+				"TLS handshake: 25 ms\n" +
+				"Server processing: 87 ms\n" +
+				"Content transfer: 0 ms\n",
+			want: map[string]interface{}{
+				"dns_lookup_ms":        12.0,
+				"tcp_connection_ms":    3.0,
+				"tls_handshake_ms":     25.0,
+				"server_processing_ms": 87.0,
+				"content_transfer_ms":  0.0,
+			},
+		},
+		{
+			name:   "truncated",
+			output: "DNS lookup: 12 ms\nTCP connection: 3 ms\nTLS hands",
+			want: map[string]interface{}{
+				"dns_lookup_ms":     12.0,
+				"tcp_connection_ms": 3.0,
+			},
+		},
+		{
+			name:   "garbage",
+			output: "Get \"https://google.invalid\": dial tcp: lookup google.invalid: no such host\n\x00\xff",
+			want:   map[string]interface{}{},
+		},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, map[string]interface{}{"timings": tt.want}, parseLegacyHTTPStat(tt.output), "The output must be parsed: %s", tt.name)
+	}
+}
+
+func TestParseLegacyCurl(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   map[string]interface{}
+	}{
+		{
+			name:   "complete",
+			output: "{\"time_namelookup\": 0.012345, \"time_connect\": 0.015, \"time_appconnect\": 0.04, \"time_pretransfer\": 0.040112, \"time_redirect\": 0, \"time_starttransfer\": 0.127, \"time_total\": 0.1275}\n",
+			want: map[string]interface{}{
+				"time_namelookup":    0.012345,
+				"time_connect":       0.015,
+				"time_appconnect":    0.04,
+				"time_pretransfer":   0.040112,
+				"time_redirect":      0.0,
+				"time_starttransfer": 0.127,
+				"time_total":         0.1275,
+			},
+		},
+		{
+			name:   "after other output",
+			output: "curl: (6) Could not resolve host: google.invalid\n{\"time_namelookup\": 0.001, \"time_total\": 0.001}\n",
+			want: map[string]interface{}{
+				"time_namelookup": 0.001,
+				"time_total":      0.001,
+			},
+		},
+		{
+			name:   "truncated",
+			output: "{\"time_namelookup\": 0.012345, \"time_connect\": 0.0",
+			want:   map[string]interface{}{},
+		},
+		{
+			name:   "garbage",
+			output: "\x00\xff",
+			want:   map[string]interface{}{},
+		},
+		{
+			name:   "empty",
+			output: "",
+			want:   map[string]interface{}{},
+		},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, parseLegacyCurl(tt.output), "The output must be parsed: %s", tt.name)
+	}
+}
@@ -157,6 +157,8 @@ func GetResults(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		probe, _ := metadata["Probe"].(string)
+
 		count := 0
 		iter := client.Collection(firestoreCollectionName).Doc(taskID).Collections(r.Context())
 
@@ -214,7 +216,15 @@ func GetResults(w http.ResponseWriter, r *http.Request) {
 					return
 				}
 
-				seq[int(valInt)] = doc.Data()
+				data := doc.Data()
+				if output, ok := data["Result"].(string); ok {
+					data["Result"] = convertLegacyResult(probe, output)
+				}
+				// Older agents only stored successful measurements.
+				if _, ok := data["Outcome"]; !ok {
+					data["Outcome"] = "success"
+				}
+				seq[int(valInt)] = data
 			}
 
 			reg[collRef.ID] = seq
@@ -223,9 +233,7 @@ func GetResults(w http.ResponseWriter, r *http.Request) {
 		metadata["ID"] = taskID
 		metadata["Results"] = reg
 
-		b, _ := json.Marshal(metadata)
-
-		sendRespond(w, http.StatusOK, string(b))
+		sendJSON(w, http.StatusOK, metadata)
 		return
 	default:
 		sendRespond(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
@@ -19,3 +19,17 @@ func sendRespond(w http.ResponseWriter, status int, msg string) {
 	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(HTTPResponse{Code: status, Message: msg})
 }
+
+// Data structure for a JSON document returned on HTTP calls
+type JSONResponse struct {
+	Code    int         `json:"code"`
+	Message interface{} `json:"message"`
+}
+
+// A function that return a JSON document and code on HTTP calls
+func sendJSON(w http.ResponseWriter, status int, msg interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(JSONResponse{Code: status, Message: msg})
+}
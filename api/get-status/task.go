@@ -30,6 +30,11 @@ type task struct {
 	Type             string
 	Status           string
 	NumberOfSequence map[string]int
+	Timeout          int
+	Samples          int
+	Interval         float64
+	Targets          []string
+	RegionArguments  map[string]string
 }
 
 func newTask() (*task, error) {
@@ -27,6 +27,12 @@ type httpResponse struct {
 	Message string `json:"message"`
 }
 
+// Data structure for response carrying a JSON document on HTTP calls
+type jsonResponse struct {
+	Code    int             `json:"code"`
+	Message json.RawMessage `json:"message"`
+}
+
 var logger = log.GetLogger("server")
 
 func SendTask(task *tasks.Task) {
@@ -177,13 +183,20 @@ func GetResults(taskID string) {
 		return
 	}
 
-	response := &httpResponse{}
+	response := &jsonResponse{}
 	err = json.Unmarshal(body, response)
 	if err != nil {
 		logger.Error(err)
 		return
 	}
 
+	// Older servers return the results as a JSON document encoded in a string.
+	results := []byte(response.Message)
+	var message string
+	if err := json.Unmarshal(response.Message, &message); err == nil {
+		results = []byte(message)
+	}
+
 	if resp.StatusCode == 404 {
 		logger.Errorf("Error 404: cannot find a task with ID: %s", taskID)
 		return
@@ -194,13 +207,13 @@ func GetResults(taskID string) {
 		logger.Info("The results are not ready yet. Please try again later.")
 		return
 	} else if resp.StatusCode != 200 {
-		logger.Errorf("Error %d: %s", resp.StatusCode, string(response.Message))
+		logger.Errorf("Error %d: %s", resp.StatusCode, string(results))
 		return
 	}
 
 	var prettyJSON bytes.Buffer
-	error := json.Indent(&prettyJSON, []byte(response.Message), "", "  ")
-	if error != nil {
+	err = json.Indent(&prettyJSON, results, "", "  ")
+	if err != nil {
 		logger.Error(err)
 		return
 	}
@@ -1,10 +1,15 @@
 package tasks
 
 type Task struct {
-	VantagePoints []string
-	Probe         string
-	Arguments     string
-	Schedule      *schedule
+	VantagePoints   []string
+	Probe           string
+	Arguments       string
+	Schedule        *schedule
+	Timeout         int
+	Samples         int
+	Interval        float64
+	Targets         []string
+	RegionArguments map[string]string
 }
 
 func NewTask() *Task {
@@ -35,11 +35,3 @@ var regions = map[string]string{
 	// "southamerica-west1":      "Santiago, Chile",
 	// "us-east5":                "Columbus",
 }
-
-var probes = map[string]interface{}{
-	"ping":       nil,
-	"traceroute": nil,
-	"httpstat":   nil,
-	"null":       nil,
-	"curl":       nil,
-}
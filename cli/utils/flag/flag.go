@@ -10,6 +10,8 @@ import (
 
 	"github.com/rafikurnia/measurement-cli/tasks"
 	"github.com/rafikurnia/measurement-cli/utils/log"
+
+	"github.com/rafikurnia/measurement-measurer/probes"
 )
 
 const (
@@ -41,14 +43,20 @@ func Parse() (string, interface{}) {
 	case MeasureCommand:
 		cfg := tasks.NewTask()
 
-		var startTime, stopTime, cronExpr, vantagePoints string
+		var startTime, stopTime, cronExpr, vantagePoints, targetsFile string
 		subMeasure := flag.NewFlagSet(MeasureCommand, flag.ExitOnError)
 		subMeasure.StringVar(&vantagePoints, "r", "", "[required] a comma delimited list of Google Cloud regions")
 		subMeasure.StringVar(&cfg.Probe, "p", "", "[required] the ID of the measurement probe (e.g., ping)")
 		subMeasure.StringVar(&cfg.Arguments, "a", "", "[required] the arguments for the measurement (e.g., google.com)")
+		overrides := make(regionArguments)
+		subMeasure.Var(overrides, "o", "the arguments for a given region instead of -a, in the form region=arguments, may be repeated")
+		subMeasure.StringVar(&targetsFile, "f", "", "a file with one target per line, each appended to the arguments in turn (e.g., -a '-c 3' -f hosts.txt)")
 		subMeasure.StringVar(&startTime, "s", "", fmt.Sprintf("the start time of a measurement, in ISO format (e.g., %s) or leave empty for as soon as possible", tasks.ISOTimeFormat))
 		subMeasure.StringVar(&stopTime, "e", "", fmt.Sprintf("the end (stop) time of the measurement, in ISO format (e.g., %s) or leave empty for one-off measurement", tasks.ISOTimeFormat))
 		subMeasure.StringVar(&cronExpr, "c", "* * * * *", "cron expression (in UTC) to execute the measurement, it is ignored on one-off measurement")
+		subMeasure.IntVar(&cfg.Samples, "n", 1, "the number of samples taken in each measurement")
+		subMeasure.Float64Var(&cfg.Interval, "i", 1, "the number of seconds between samples, it is ignored with a single sample")
+		subMeasure.IntVar(&cfg.Timeout, "t", 0, "the maximum number of seconds for a single measurement (at most 150), or 0 for the default of 60")
 
 		subMeasure.Parse(os.Args[2:])
 		if subMeasure.Parsed() {
@@ -88,20 +96,55 @@ func Parse() (string, interface{}) {
 				isError = true
 			}
 
-			if _, ok := probes[cfg.Probe]; !ok {
-				logger.Errorf("The specified measurement probe is not supported. Supported values are: [%v].", strings.Join(maps.Keys(probes), "|"))
+			if targetsFile != "" {
+				targets, err := readTargets(targetsFile)
+				if err != nil {
+					logger.Errorf("An error occurred when reading the targets: %v", err)
+					isError = true
+				} else if len(targets) == 0 {
+					logger.Errorf("The file does not contain any target: '%s'.", targetsFile)
+					isError = true
+				}
+				cfg.Targets = targets
+			}
+
+			probe, ok := probes.Lookup(cfg.Probe)
+			if !ok {
+				logger.Errorf("The specified measurement probe is not supported. Supported values are: [%v].", strings.Join(probes.Names(), "|"))
+				isError = true
+			} else {
+				if !validateArguments(probe, cfg.Arguments, cfg.Targets) {
+					isError = true
+				}
+				for region, args := range overrides {
+					if !contains(cfg.VantagePoints, region) {
+						logger.Errorf("The region with its own arguments is not a vantage point: '%s'.", region)
+						isError = true
+					} else if !validateArguments(probe, args, cfg.Targets) {
+						isError = true
+					}
+				}
+			}
+			if len(overrides) > 0 {
+				cfg.RegionArguments = overrides
+			}
+
+			if cfg.Timeout < 0 || cfg.Timeout > 150 {
+				logger.Errorf("The timeout must be between 0 and 150 seconds, got %d.", cfg.Timeout)
 				isError = true
 			}
 
-			if cfg.Arguments == "" {
-				logger.Error("The arguments for the measurement probe cannot be empty.")
+			if cfg.Samples < 1 || cfg.Samples > 100 {
+				logger.Errorf("The number of samples must be between 1 and 100, got %d.", cfg.Samples)
 				isError = true
 			}
 
-			if cfg.Probe == "httpstat" &&
-				!strings.HasPrefix(cfg.Arguments, "http://") &&
-				!strings.HasPrefix(cfg.Arguments, "https://") {
-				logger.Error("The arguments must contain URL starts with either 'http://' or 'https://'.")
+			timeout := cfg.Timeout
+			if timeout == 0 {
+				timeout = 60
+			}
+			if cfg.Interval < 0 || float64(cfg.Samples-1)*cfg.Interval >= float64(timeout) {
+				logger.Errorf("The samples must fit within %d seconds, got %d samples %g seconds apart.", timeout, cfg.Samples, cfg.Interval)
 				isError = true
 			}
 
@@ -174,3 +217,35 @@ func Parse() (string, interface{}) {
 
 	return "", nil
 }
+
+// validateArguments logs why the arguments, with each of the targets if
+// any, are invalid for the probe, and reports whether they are valid.
+func validateArguments(probe probes.Probe, args string, targets []string) bool {
+	logger := log.GetLogger("flag")
+
+	if len(targets) == 0 {
+		if err := probe.Validate(args); err != nil {
+			logger.Error(err)
+			return false
+		}
+		return true
+	}
+
+	valid := true
+	for _, target := range targets {
+		if err := probe.Validate(probes.WithTarget(args, target)); err != nil {
+			logger.Errorf("%s: %v", target, err)
+			valid = false
+		}
+	}
+	return valid
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
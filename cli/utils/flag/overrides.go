@@ -0,0 +1,27 @@
+package flag
+
+import (
+	"fmt"
+	"strings"
+)
+
+// regionArguments collects repeated "region=arguments" flags.
+type regionArguments map[string]string
+
+func (r regionArguments) String() string {
+	pairs := make([]string, 0, len(r))
+	for region, args := range r {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", region, args))
+	}
+	return strings.Join(pairs, ", ")
+}
+
+func (r regionArguments) Set(value string) error {
+	region, args, ok := strings.Cut(value, "=")
+	region = strings.TrimSpace(region)
+	if !ok || region == "" {
+		return fmt.Errorf("The value must be in the form region=arguments, got '%s'.", value)
+	}
+	r[region] = strings.TrimSpace(args)
+	return nil
+}
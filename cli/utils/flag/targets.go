@@ -0,0 +1,34 @@
+package flag
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// readTargets reads one target per line from a file, skipping blank lines,
+// comments starting with '#' and duplicates.
+func readTargets(name string) ([]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("os.Open -> %w", err)
+	}
+	defer f.Close()
+
+	targets := make([]string, 0)
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || seen[line] {
+			continue
+		}
+		seen[line] = true
+		targets = append(targets, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("scanner.Err -> %w", err)
+	}
+	return targets, nil
+}